@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"georep/geoguessr"
+	"georep/store"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Maps are described with this, so that they can be told apart from hand-made maps on the same
+// account even without the local records of the runs that created them.
+const mapMarker = "Drill generated by georep for "
+
+// Which of the maps georep created are kept around.
+type retention struct {
+	// Maps are only deleted once they are at least this old.
+	olderThan time.Duration
+
+	// The newest maps of each user are kept, however old.
+	keep int
+
+	// Maps with challenges that nobody has played yet are kept, however old.
+	keepUnfinished bool
+}
+
+var defaultRetention = retention{
+	olderThan:      7 * 24 * time.Hour,
+	keep:           3,
+	keepUnfinished: true,
+}
+
+// A map on the account that georep created.
+type ownedMap struct {
+	id      string
+	name    string
+	user    string
+	created time.Time
+	runs    []*store.Run
+}
+
+// Returns the description of a new map for the user.
+func mapDescription(user string) string {
+	return mapMarker + user
+}
+
+// Deletes the maps that georep created and that are no longer kept, or only lists them.
+func cleanup(ctx context.Context, args []string) {
+	var (
+		dryRun         bool
+		keep           int
+		keepUnfinished bool
+		olderThan      int
+	)
+
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	flags.BoolVar(&dryRun, "dry-run", false, "list the maps that would be deleted without deleting them")
+	flags.IntVar(&keep, "keep", defaultRetention.keep, "number of each user's newest maps to keep")
+	flags.BoolVar(&keepUnfinished, "keep-unfinished", defaultRetention.keepUnfinished, "keep maps with challenges that nobody has played yet")
+	flags.IntVar(&olderThan, "older-than", int(defaultRetention.olderThan.Hours()/24), "only delete maps at least this many days old")
+
+	flags.Parse(args)
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("finding state directory: %v", err)
+	}
+
+	runs, err := store.Open(dir)
+	if err != nil {
+		log.Fatalf("opening store: %v", err)
+	}
+
+	gc, err := geoguessr.NewGeoguessrClient()
+	if err != nil {
+		log.Fatalf("creating geoguessr client: %v", err)
+	}
+
+	// Maps left behind by interrupted runs aren't kept by any rule.
+	if !dryRun {
+		publisher, err := geoguessr.OpenPublisher(gc, dir)
+		if err != nil {
+			log.Fatalf("opening publisher: %v", err)
+		}
+		err = publisher.Recover(ctx)
+		if err != nil {
+			log.Fatalf("recovering interrupted runs: %v", err)
+		}
+	}
+
+	r := retention{
+		olderThan:      time.Duration(olderThan) * 24 * time.Hour,
+		keep:           keep,
+		keepUnfinished: keepUnfinished,
+	}
+	err = cleanupMaps(ctx, gc, runs, r, dryRun)
+	if err != nil {
+		log.Fatalf("cleaning up maps: %v", err)
+	}
+}
+
+func cleanupMaps(ctx context.Context, gc *geoguessr.GeoguessrClient, runs *store.Store, r retention, dryRun bool) error {
+	maps, err := gc.ListMaps(ctx)
+	if err != nil {
+		return fmt.Errorf("listing maps: %v", err)
+	}
+
+	// A challenge counts as unfinished until it has results. Failing to get them keeps the map, so
+	// that a challenge isn't deleted from under someone because of a hiccup.
+	unfinished := func(runs []*store.Run) bool {
+		for _, run := range runs {
+			if run.ChallengeToken == "" {
+				continue
+			}
+			results, err := gc.GetChallengeResults(ctx, geoguessr.GetChallengeResultsRequest{Id: run.ChallengeToken})
+			if err != nil {
+				log.Printf("getting results for challenge %s: %v", run.ChallengeToken, err)
+				return true
+			}
+			if len(results.Items) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	expired := r.expired(ownedMaps(maps, runs), time.Now(), unfinished)
+
+	deleted := 0
+	for _, m := range expired {
+		if dryRun {
+			log.Printf(`would delete map "%s" (%s) of %s, created %s`, m.name, m.id, m.user, m.created.Format(time.DateOnly))
+			continue
+		}
+
+		delete := geoguessr.DeleteMapRequest{
+			Id: m.id,
+		}
+		err = gc.DeleteMap(ctx, delete)
+		if err != nil {
+			return fmt.Errorf("deleting map %s: %v", m.id, err)
+		}
+		deleted++
+	}
+
+	if !dryRun {
+		log.Printf("deleted %d of %d maps\n", deleted, len(maps))
+	}
+	return nil
+}
+
+// Returns the maps that georep created, from the runs that used them or from their description,
+// except for long-lived ones.
+func ownedMaps(maps []geoguessr.Map, runs *store.Store) []ownedMap {
+	owned := make([]ownedMap, 0)
+	for _, m := range maps {
+		// Long-lived maps are reused every session, so they are never cleaned up.
+		if runs.IsPersistentMap(m.ID) {
+			continue
+		}
+
+		o := ownedMap{
+			id:      m.ID,
+			name:    m.Name,
+			created: m.CreatedAt,
+			runs:    runs.FindByMap(m.ID),
+		}
+
+		if description, ok := m.Description.(string); ok && strings.HasPrefix(description, mapMarker) {
+			o.user = strings.TrimPrefix(description, mapMarker)
+		}
+		for _, run := range o.runs {
+			o.user = run.User
+			if o.created.IsZero() || run.Created.Before(o.created) {
+				o.created = run.Created
+			}
+		}
+
+		if o.user != "" {
+			owned = append(owned, o)
+		}
+	}
+	return owned
+}
+
+// Returns the maps that aren't kept by any of the rules. Whether a map's challenges are unfinished
+// is only looked up for maps that no other rule keeps.
+func (r retention) expired(owned []ownedMap, now time.Time, unfinished func([]*store.Run) bool) []ownedMap {
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].created.After(owned[j].created)
+	})
+
+	expired := make([]ownedMap, 0)
+	newer := make(map[string]int)
+	for _, m := range owned {
+		newer[m.user]++
+		if newer[m.user] <= r.keep || now.Sub(m.created) < r.olderThan {
+			continue
+		}
+		if r.keepUnfinished && unfinished(m.runs) {
+			continue
+		}
+		expired = append(expired, m)
+	}
+	return expired
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"georep/geoguessr"
+	"georep/store"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// Returns a map of the user created the given number of days ago, with a run that used it.
+func owned(id string, user string, days int) ownedMap {
+	return ownedMap{
+		id:      id,
+		user:    user,
+		created: now.Add(-time.Duration(days) * 24 * time.Hour),
+		runs:    []*store.Run{{MapId: id}},
+	}
+}
+
+// Returns the ids of the maps, sorted.
+func mapIds(maps []ownedMap) []string {
+	ids := make([]string, 0, len(maps))
+	for _, m := range maps {
+		ids = append(ids, m.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestExpired(t *testing.T) {
+	week := 7 * 24 * time.Hour
+
+	tests := []struct {
+		name       string
+		retention  retention
+		owned      []ownedMap
+		unfinished []string
+		want       []string
+		asked      []string
+	}{
+		{
+			name:      "keeps the newest of each user",
+			retention: retention{keep: 2},
+			owned: []ownedMap{
+				owned("a1", "a", 40), owned("a2", "a", 30), owned("a3", "a", 20), owned("a4", "a", 10),
+				owned("b1", "b", 50),
+			},
+			want:  []string{"a1", "a2"},
+			asked: []string{},
+		},
+		{
+			name:      "keeps young maps",
+			retention: retention{olderThan: week},
+			owned:     []ownedMap{owned("young", "a", 1), owned("edge", "a", 7), owned("old", "a", 8)},
+			want:      []string{"edge", "old"},
+			asked:     []string{},
+		},
+		{
+			name:      "only asks about maps no other rule keeps",
+			retention: retention{olderThan: week, keep: 1, keepUnfinished: true},
+			owned: []ownedMap{
+				owned("newest", "a", 20), owned("unfinished", "a", 30), owned("finished", "a", 40),
+				owned("young", "b", 1), owned("old", "b", 30),
+			},
+			unfinished: []string{"newest", "unfinished", "young"},
+			want:       []string{"finished", "old"},
+			asked:      []string{"finished", "old", "unfinished"},
+		},
+		{
+			name:       "ignores unfinished challenges unless asked to keep them",
+			retention:  retention{olderThan: week},
+			owned:      []ownedMap{owned("unfinished", "a", 30)},
+			unfinished: []string{"unfinished"},
+			want:       []string{"unfinished"},
+			asked:      []string{},
+		},
+	}
+
+	for _, test := range tests {
+		asked := make([]string, 0)
+		unfinished := func(runs []*store.Run) bool {
+			asked = append(asked, runs[0].MapId)
+			for _, id := range test.unfinished {
+				if runs[0].MapId == id {
+					return true
+				}
+			}
+			return false
+		}
+
+		got := mapIds(test.retention.expired(test.owned, now, unfinished))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expired %v, want %v", test.name, got, test.want)
+		}
+		sort.Strings(asked)
+		if !reflect.DeepEqual(asked, test.asked) {
+			t.Errorf("%s: asked about %v, want %v", test.name, asked, test.asked)
+		}
+	}
+}
+
+func TestOwnedMaps(t *testing.T) {
+	runs, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs.SetPersistentMap("a", "persistent")
+	runs.Add(&store.Run{User: "a", MapId: "run", Created: now.Add(-time.Hour)})
+
+	maps := []geoguessr.Map{
+		{ID: "persistent", Description: mapDescription("a"), CreatedAt: now},
+		{ID: "run", CreatedAt: now},
+		{ID: "described", Description: mapDescription("b"), CreatedAt: now},
+		{ID: "hand-made", Description: "my favourite spots", CreatedAt: now},
+		{ID: "undescribed", CreatedAt: now},
+	}
+
+	got := ownedMaps(maps, runs)
+	if ids := mapIds(got); !reflect.DeepEqual(ids, []string{"described", "run"}) {
+		t.Fatalf("owned %v, want [described run]", ids)
+	}
+	for _, m := range got {
+		switch m.id {
+		case "run":
+			if m.user != "a" || !m.created.Equal(now.Add(-time.Hour)) || len(m.runs) != 1 {
+				t.Errorf("expected the map's run to give its user and creation, got %+v", m)
+			}
+		case "described":
+			if m.user != "b" || !m.created.Equal(now) {
+				t.Errorf("expected the description to give the map's user, got %+v", m)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package coverage
+
+import (
+	"context"
+	"fmt"
+	"georep/googlemaps"
+	"georep/internal/transport"
+	"math"
+	"net/url"
+	"os"
+	"time"
+)
+
+// The Google Maps client checks coverage against Street View.
+var _ CoverageProvider = (*googlemaps.GoogleMapsClient)(nil)
+
+var _ CoverageProvider = (*MapillaryClient)(nil)
+
+// Same as the default radius of the Street View metadata API.
+const DefaultMapillaryRadius = 50.0
+
+func NewMapillaryClient() (*MapillaryClient, error) {
+	token, ok := os.LookupEnv("MAPILLARY_ACCESS_TOKEN")
+	if !ok {
+		return nil, fmt.Errorf("mapillary access token environment variable not set")
+	}
+
+	return &MapillaryClient{
+		Client:  transport.New(nil),
+		Auth:    token,
+		BaseURL: "https://graph.mapillary.com",
+		Radius:  DefaultMapillaryRadius,
+	}, nil
+}
+
+// Only 360° images count as coverage, since GeoGuessr rounds can be looked around in. They are all
+// photospheres, so policies that don't allow those never find any. Returns the nearest one to the
+// location that meets the policy, if there is one.
+func (mc *MapillaryClient) ValidateCoverage(ctx context.Context, latlong [2]float64, policy googlemaps.CoveragePolicy) (googlemaps.Panorama, bool, error) {
+	if !policy.AllowsType(googlemaps.CoveragePhotosphere) {
+		return googlemaps.Panorama{}, false, nil
+	}
+
+	images, err := mc.getImages(ctx, latlong)
+	if err != nil {
+		return googlemaps.Panorama{}, false, err
+	}
+
+	var pano googlemaps.Panorama
+	nearest := math.MaxFloat64
+	for _, image := range images {
+		if !image.IsPano {
+			continue
+		}
+
+		date := time.UnixMilli(image.CapturedAt).UTC().Format("2006-01")
+		if image.CapturedAt == 0 {
+			date = ""
+		}
+		if !policy.AllowsDate(date) {
+			continue
+		}
+
+		location := [2]float64{image.Geometry.Coordinates[1], image.Geometry.Coordinates[0]}
+		d := googlemaps.Distance(latlong, location)
+		if d > mc.Radius || d >= nearest {
+			continue
+		}
+
+		nearest = d
+		pano = googlemaps.Panorama{
+			Id:        image.Id,
+			Location:  location,
+			Date:      date,
+			Copyright: fmt.Sprintf("© %s, Mapillary", image.Creator.Username),
+			Source:    "mapillary",
+			Type:      googlemaps.CoveragePhotosphere,
+		}
+	}
+
+	return pano, nearest != math.MaxFloat64, nil
+}
+
+// Returns the images in a box around the location that's just big enough to hold the radius.
+func (mc *MapillaryClient) getImages(ctx context.Context, latlong [2]float64) ([]Image, error) {
+	dLat := mc.Radius / googlemaps.EarthRadius * 180 / math.Pi
+	dLong := dLat / math.Max(math.Cos(latlong[0]*math.Pi/180), 0.01)
+	bbox := fmt.Sprintf("%f,%f,%f,%f", latlong[1]-dLong, latlong[0]-dLat, latlong[1]+dLong, latlong[0]+dLat)
+
+	query := url.Values{}
+	query.Set("access_token", mc.Auth)
+	query.Set("fields", "id,geometry,captured_at,is_pano,creator")
+	query.Set("bbox", bbox)
+	query.Set("is_pano", "true")
+	query.Set("limit", "100")
+
+	req := transport.Request{
+		Method: "GET",
+		URL:    mc.BaseURL + "/images?" + query.Encode(),
+		API:    "mapillary images API",
+	}
+
+	var response GetImagesResponse
+	err := mc.Client.DoJSON(ctx, req, nil, &response)
+	if err != nil {
+		return []Image{}, err
+	}
+	return response.Data, nil
+}
@@ -0,0 +1,164 @@
+package coverage
+
+import (
+	"context"
+	"encoding/json"
+	"georep/googlemaps"
+	"georep/internal/transport"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var origin = [2]float64{45, 7}
+
+// Mapillary panoramas are all photospheres.
+var photospheres = googlemaps.CoveragePolicy{Types: []googlemaps.CoverageType{googlemaps.CoveragePhotosphere}}
+
+// Returns an image offset from the origin by dLat degrees north.
+func image(id string, dLat float64, isPano bool, capturedAt time.Time) Image {
+	var i Image
+	i.Id = id
+	i.Geometry.Coordinates = [2]float64{origin[1], origin[0] + dLat}
+	i.IsPano = isPano
+	i.CapturedAt = capturedAt.UnixMilli()
+	i.Creator.Username = "someone"
+	return i
+}
+
+// Returns a client pointed at a server that answers every request with the images.
+func newTestClient(t *testing.T, images []Image) *MapillaryClient {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("access_token") != "token" {
+			t.Errorf("access token not sent")
+		}
+		json.NewEncoder(w).Encode(GetImagesResponse{Data: images})
+	}))
+	t.Cleanup(srv.Close)
+
+	return &MapillaryClient{
+		Client:  transport.New(srv.Client()),
+		Auth:    "token",
+		BaseURL: srv.URL,
+		Radius:  DefaultMapillaryRadius,
+	}
+}
+
+func TestValidateCoverageNoImages(t *testing.T) {
+	mc := newTestClient(t, []Image{})
+
+	_, ok, err := mc.ValidateCoverage(context.Background(), origin, photospheres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expected no coverage without images")
+	}
+}
+
+func TestValidateCoverageSkipsFlatImages(t *testing.T) {
+	captured := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	mc := newTestClient(t, []Image{image("flat", 0.0001, false, captured)})
+
+	_, ok, err := mc.ValidateCoverage(context.Background(), origin, photospheres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expected images that aren't panoramas not to count as coverage")
+	}
+}
+
+func TestValidateCoveragePicksNearestWithinRadius(t *testing.T) {
+	captured := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	mc := newTestClient(t, []Image{
+		image("far", 0.0004, true, captured),
+		image("near", 0.0001, true, captured),
+		image("outside", 0.001, true, captured),
+		image("flat", 0.00001, false, captured),
+	})
+
+	pano, ok, err := mc.ValidateCoverage(context.Background(), origin, photospheres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("expected coverage")
+	}
+	if pano.Id != "near" {
+		t.Errorf("expected the nearest panorama, got %s", pano.Id)
+	}
+	if pano.Location != [2]float64{origin[0] + 0.0001, origin[1]} {
+		t.Errorf("expected location as (lat, long), got %v", pano.Location)
+	}
+	if pano.Date != "2022-06" || pano.Source != "mapillary" {
+		t.Errorf("unexpected date %q or source %q", pano.Date, pano.Source)
+	}
+
+	mc = newTestClient(t, []Image{image("outside", 0.001, true, captured)})
+	_, ok, err = mc.ValidateCoverage(context.Background(), origin, photospheres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expected panoramas outside the radius not to count as coverage")
+	}
+}
+
+func TestValidateCoverageFiltersDates(t *testing.T) {
+	mc := newTestClient(t, []Image{
+		image("old", 0.0001, true, time.Date(2012, 3, 1, 0, 0, 0, 0, time.UTC)),
+		image("new", 0.0002, true, time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)),
+	})
+
+	policy := photospheres
+	policy.CapturedAfter = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	pano, ok, err := mc.ValidateCoverage(context.Background(), origin, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || pano.Id != "new" {
+		t.Errorf("expected the panorama captured after %s, got %v", policy.CapturedAfter.Format("2006-01"), pano)
+	}
+
+	policy = photospheres
+	policy.CapturedBefore = time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, ok, err = mc.ValidateCoverage(context.Background(), origin, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("expected no panorama captured before %s", policy.CapturedBefore.Format("2006-01"))
+	}
+}
+
+func TestValidateCoverageErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"Invalid OAuth access token"}}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	mc := &MapillaryClient{
+		Client:  transport.New(srv.Client()),
+		Auth:    "token",
+		BaseURL: srv.URL,
+		Radius:  DefaultMapillaryRadius,
+	}
+
+	_, ok, err := mc.ValidateCoverage(context.Background(), origin, photospheres)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if ok {
+		t.Errorf("expected no coverage on error")
+	}
+	if !transport.IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("expected a 401 API error, got %v", err)
+	}
+}
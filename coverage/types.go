@@ -0,0 +1,41 @@
+package coverage
+
+import (
+	"context"
+	"georep/googlemaps"
+	"georep/internal/transport"
+)
+
+// A source of street-level imagery that locations can be checked for coverage against.
+type CoverageProvider interface {
+	// Returns the panorama nearest to the location if it is valid coverage under the policy.
+	ValidateCoverage(ctx context.Context, latlong [2]float64, policy googlemaps.CoveragePolicy) (googlemaps.Panorama, bool, error)
+}
+
+type MapillaryClient struct {
+	Client *transport.Client
+	Auth   string
+
+	// Root of the Graph API, which can be pointed at another server.
+	BaseURL string
+
+	// Images are looked for within this many meters of each location.
+	Radius float64
+}
+
+type GetImagesResponse struct {
+	Data []Image `json:"data"`
+}
+
+type Image struct {
+	Id       string `json:"id"`
+	Geometry struct {
+		// As (long, lat), like GeoJSON.
+		Coordinates [2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	CapturedAt int64 `json:"captured_at"`
+	IsPano     bool  `json:"is_pano"`
+	Creator    struct {
+		Username string `json:"username"`
+	} `json:"creator"`
+}
@@ -0,0 +1,21 @@
+package data
+
+import "fmt"
+
+// Locations this close to a subdivision are considered to be in it. The Natural Earth boundaries are
+// generalised, so coastal roads, piers and bridges often fall just outside them.
+const coastalTolerance = 2000.0
+
+// Returns the subdivision containing the (lat, long) location, without calling any API.
+func ReverseGeocode(location [2]float64) (Feature, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return Feature{}, err
+	}
+
+	subdivision, ok := ix.Nearest(location, coastalTolerance)
+	if !ok {
+		return Feature{}, fmt.Errorf("no subdivision contains %v", location)
+	}
+	return subdivision.Feature, nil
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"georep/googlemaps"
+	"math"
+	"math/rand/v2"
+)
+
+// Returns the geometry of the feature. Polygons are returned as a multipolygon with a single part.
+func (f Feature) MultiPolygon() (MultiPolygon, error) {
+	var parts [][][][2]float64
+	switch f.Geometry.Type {
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &polygon); err != nil {
+			return MultiPolygon{}, err
+		}
+		parts = append(parts, polygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(f.Geometry.Coordinates, &parts); err != nil {
+			return MultiPolygon{}, err
+		}
+	default:
+		return MultiPolygon{}, fmt.Errorf("unsupported geometry type %v", f.Geometry.Type)
+	}
+
+	multi := make(MultiPolygon, 0, len(parts))
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+
+		// The GeoJSON is (long, lat) instead of (lat, long).
+		rings := make([]Ring, 0, len(part))
+		for _, coordinates := range part {
+			ring := make(Ring, 0, len(coordinates))
+			for _, coordinate := range coordinates {
+				ring = append(ring, [2]float64{coordinate[1], coordinate[0]})
+			}
+			rings = append(rings, ring)
+		}
+		multi = append(multi, Polygon{Outer: rings[0], Holes: rings[1:]})
+	}
+	return multi, nil
+}
+
+func (p Polygon) Contains(point [2]float64) bool {
+	if !isPointInPolygon(point, p.Outer) {
+		return false
+	}
+	for _, hole := range p.Holes {
+		if isPointInPolygon(point, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m MultiPolygon) Contains(point [2]float64) bool {
+	for _, polygon := range m {
+		if polygon.Contains(point) {
+			return true
+		}
+	}
+	return false
+}
+
+// Projects the point onto a plane in meters (east, north) centred on the origin. This is an
+// equirectangular projection, which is accurate enough over the few kilometers it is used for.
+func project(origin [2]float64, point [2]float64) (float64, float64) {
+	scale := math.Pi / 180 * googlemaps.EarthRadius
+	return (point[1] - origin[1]) * scale * math.Cos(origin[0]*math.Pi/180), (point[0] - origin[0]) * scale
+}
+
+// Returns how far along the segment from a to b, as a fraction of its length, the point on it
+// closest to the origin is, and the distance in meters to that point.
+func closestOnSegment(origin [2]float64, a [2]float64, b [2]float64) (float64, float64) {
+	ax, ay := project(origin, a)
+	bx, by := project(origin, b)
+
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = min(max(-(ax*dx+ay*dy)/length, 0), 1)
+	}
+	return t, math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// Approximate distance in meters from the point to the nearest edge of the ring.
+func (r Ring) Distance(point [2]float64) float64 {
+	nearest := math.MaxFloat64
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		_, d := closestOnSegment(point, r[j], r[i])
+		nearest = min(nearest, d)
+	}
+	return nearest
+}
+
+// Distance in meters from the point to the multipolygon, which is zero for points inside it.
+func (m MultiPolygon) Distance(point [2]float64) float64 {
+	if m.Contains(point) {
+		return 0
+	}
+	return m.BoundaryDistance(point)
+}
+
+// Distance in meters from the point to the nearest edge of any ring, whether it is inside or not.
+func (m MultiPolygon) BoundaryDistance(point [2]float64) float64 {
+	nearest := math.MaxFloat64
+	for _, polygon := range m {
+		nearest = min(nearest, polygon.Outer.Distance(point))
+		for _, hole := range polygon.Holes {
+			nearest = min(nearest, hole.Distance(point))
+		}
+	}
+	return nearest
+}
+
+// Area of the ring on a spherical earth in square meters, regardless of its winding order.
+func (r Ring) Area() float64 {
+	area := 0.0
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		lat1, lat2 := r[j][0]*math.Pi/180, r[i][0]*math.Pi/180
+		dLong := (r[i][1] - r[j][1]) * math.Pi / 180
+		area += dLong * (2 + math.Sin(lat1) + math.Sin(lat2))
+	}
+	return math.Abs(area * googlemaps.EarthRadius * googlemaps.EarthRadius / 2)
+}
+
+func (p Polygon) Area() float64 {
+	area := p.Outer.Area()
+	for _, hole := range p.Holes {
+		area -= hole.Area()
+	}
+	return max(area, 0)
+}
+
+// Returns the area of each part in square meters.
+func (m MultiPolygon) PartAreas() []float64 {
+	areas := make([]float64, len(m))
+	for i, polygon := range m {
+		areas[i] = polygon.Area()
+	}
+	return areas
+}
+
+func (m MultiPolygon) Area() float64 {
+	area := 0.0
+	for _, polygon := range m {
+		area += polygon.Area()
+	}
+	return area
+}
+
+// Points are drawn from the bounding box until one falls inside the polygon. Real subdivisions fill
+// a good part of their bounding box, so this many misses means the polygon has next to no area.
+const maxRandomPointAttempts = 1000
+
+// Returns a point distributed uniformly by area within the polygon, or false if none was found.
+func (p Polygon) RandomPoint() ([2]float64, bool) {
+	min, max := getBoundingBox(p.Outer)
+
+	// Sampling latitude uniformly would crowd points towards the pole, since a degree of longitude
+	// covers less ground there. Sampling the sine of the latitude uniformly corrects for that.
+	minSin, maxSin := math.Sin(min[0]*math.Pi/180), math.Sin(max[0]*math.Pi/180)
+	for i := 0; i < maxRandomPointAttempts; i++ {
+		lat := math.Asin(minSin+rand.Float64()*(maxSin-minSin)) * 180 / math.Pi
+		long := min[1] + rand.Float64()*(max[1]-min[1])
+		point := [2]float64{lat, long}
+
+		if p.Contains(point) {
+			return point, true
+		}
+	}
+	return [2]float64{}, false
+}
+
+// Returns a point distributed uniformly by area within the multipolygon, or false if none was
+// found. Parts are chosen in proportion to their areas, as from PartAreas, so that a province's main
+// island isn't sampled as often as each islet, and parts without any area are never chosen.
+func (m MultiPolygon) RandomPoint(areas []float64) ([2]float64, bool) {
+	if len(areas) != len(m) {
+		areas = m.PartAreas()
+	}
+
+	total := 0.0
+	last := -1
+	for i, area := range areas {
+		total += area
+		if area > 0 {
+			last = i
+		}
+	}
+	if last == -1 {
+		return [2]float64{}, false
+	}
+
+	r := rand.Float64() * total
+	for i, area := range areas {
+		if r < area {
+			return m[i].RandomPoint()
+		}
+		r -= area
+	}
+	return m[last].RandomPoint()
+}
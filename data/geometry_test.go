@@ -0,0 +1,154 @@
+package data
+
+import (
+	"georep/googlemaps"
+	"math"
+	"testing"
+)
+
+// Returns the ring around the box from (lat, long) sw to ne, counterclockwise.
+func box(sw [2]float64, ne [2]float64) Ring {
+	return Ring{{sw[0], sw[1]}, {sw[0], ne[1]}, {ne[0], ne[1]}, {ne[0], sw[1]}}
+}
+
+// Returns the ring reversed, so that it is wound the other way.
+func reversed(r Ring) Ring {
+	out := make(Ring, len(r))
+	for i, point := range r {
+		out[len(r)-1-i] = point
+	}
+	return out
+}
+
+// Area of the box from (lat, long) sw to ne on a spherical earth.
+func boxArea(sw [2]float64, ne [2]float64) float64 {
+	dLong := (ne[1] - sw[1]) * math.Pi / 180
+	return googlemaps.EarthRadius * googlemaps.EarthRadius * dLong * (math.Sin(ne[0]*math.Pi/180) - math.Sin(sw[0]*math.Pi/180))
+}
+
+// Two islands, the first with a lake in it.
+var islands = MultiPolygon{
+	{Outer: box([2]float64{0, 0}, [2]float64{2, 2}), Holes: []Ring{box([2]float64{0.5, 0.5}, [2]float64{1.5, 1.5})}},
+	{Outer: box([2]float64{0, 10}, [2]float64{1, 11})},
+}
+
+// A ring along a meridian, which encloses nothing.
+var sliver = Polygon{Outer: Ring{{0, 20}, {1, 20}, {2, 20}}}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name  string
+		point [2]float64
+		want  bool
+	}{
+		{"first island", [2]float64{0.25, 0.25}, true},
+		{"lake", [2]float64{1, 1}, false},
+		{"second island", [2]float64{0.5, 10.5}, true},
+		{"sea between", [2]float64{0.5, 5}, false},
+		{"sea outside", [2]float64{-1, -1}, false},
+	}
+
+	for _, test := range tests {
+		if got := islands.Contains(test.point); got != test.want {
+			t.Errorf("%s: Contains(%v) = %v, want %v", test.name, test.point, got, test.want)
+		}
+	}
+}
+
+func TestClosestOnSegment(t *testing.T) {
+	// A hundredth of a degree of latitude.
+	step := googlemaps.Distance([2]float64{0, 0}, [2]float64{0.01, 0})
+
+	tests := []struct {
+		name     string
+		a, b     [2]float64
+		along    float64
+		distance float64
+	}{
+		{"beside the middle", [2]float64{-0.01, 0.01}, [2]float64{0.01, 0.01}, 0.5, step},
+		{"past the end", [2]float64{0.01, 0}, [2]float64{0.02, 0}, 0, step},
+		{"past the start", [2]float64{-0.02, 0}, [2]float64{-0.01, 0}, 1, step},
+		{"single point", [2]float64{0.01, 0}, [2]float64{0.01, 0}, 0, step},
+	}
+
+	for _, test := range tests {
+		along, distance := closestOnSegment([2]float64{0, 0}, test.a, test.b)
+		if math.Abs(along-test.along) > 1e-9 || math.Abs(distance-test.distance) > 1e-3*test.distance {
+			t.Errorf("%s: closestOnSegment() = %v, %.1f, want %v, %.1f", test.name, along, distance, test.along, test.distance)
+		}
+	}
+
+	// The edges of the lake are half a degree from the middle of it.
+	if got, want := islands[0].Holes[0].Distance([2]float64{1, 1}), 50*step; math.Abs(got-want) > 1e-3*want {
+		t.Errorf("Distance() = %.1f, want %.1f", got, want)
+	}
+}
+
+func TestArea(t *testing.T) {
+	tests := []struct {
+		name    string
+		polygon Polygon
+		want    float64
+	}{
+		{"box", Polygon{Outer: box([2]float64{0, 0}, [2]float64{1, 1})}, boxArea([2]float64{0, 0}, [2]float64{1, 1})},
+		{"box wound the other way", Polygon{Outer: reversed(box([2]float64{0, 0}, [2]float64{1, 1}))}, boxArea([2]float64{0, 0}, [2]float64{1, 1})},
+		{"box far north", Polygon{Outer: box([2]float64{60, 0}, [2]float64{61, 1})}, boxArea([2]float64{60, 0}, [2]float64{61, 1})},
+		{"island with a lake", islands[0], boxArea([2]float64{0, 0}, [2]float64{2, 2}) - boxArea([2]float64{0.5, 0.5}, [2]float64{1.5, 1.5})},
+		{"sliver", sliver, 0},
+	}
+
+	for _, test := range tests {
+		got := test.polygon.Area()
+		if math.Abs(got-test.want) > 1e-6*max(test.want, 1) {
+			t.Errorf("%s: Area() = %.0f, want %.0f", test.name, got, test.want)
+		}
+	}
+
+	// A degree of longitude covers less ground away from the equator.
+	if north, equator := boxArea([2]float64{60, 0}, [2]float64{61, 1}), boxArea([2]float64{0, 0}, [2]float64{1, 1}); north > equator*0.55 {
+		t.Errorf("expected the northern box to be about half the size, got %.0f and %.0f", north, equator)
+	}
+}
+
+func TestRandomPoint(t *testing.T) {
+	areas := islands.PartAreas()
+	share := areas[0] / (areas[0] + areas[1])
+
+	const draws = 4000
+	first := 0
+	for i := 0; i < draws; i++ {
+		point, ok := islands.RandomPoint(areas)
+		if !ok {
+			t.Fatalf("expected a point")
+		}
+		if !islands.Contains(point) {
+			t.Fatalf("point %v is not on either island", point)
+		}
+		if islands[0].Contains(point) {
+			first++
+		}
+	}
+
+	// The first island is three times the size of the second once its lake is taken out.
+	if got := float64(first) / draws; math.Abs(got-share) > 0.05 {
+		t.Errorf("expected %.2f of points on the first island, got %.2f", share, got)
+	}
+}
+
+func TestRandomPointSkipsSlivers(t *testing.T) {
+	withSliver := MultiPolygon{sliver, islands[1]}
+	for i := 0; i < 100; i++ {
+		point, ok := withSliver.RandomPoint(withSliver.PartAreas())
+		if !ok || !islands[1].Contains(point) {
+			t.Fatalf("expected a point on the island, got %v, %v", point, ok)
+		}
+	}
+
+	onlySliver := MultiPolygon{sliver}
+	if point, ok := onlySliver.RandomPoint(onlySliver.PartAreas()); ok {
+		t.Errorf("expected no point in a sliver, got %v", point)
+	}
+	if point, ok := sliver.RandomPoint(); ok {
+		t.Errorf("expected sampling a sliver to give up, got %v", point)
+	}
+}
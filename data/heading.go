@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"georep/googlemaps"
+	"math"
+	"math/rand/v2"
+)
+
+type HeadingMode string
+
+const (
+	// Face one way or the other along the road.
+	HeadingAlongRoad HeadingMode = "road"
+
+	// Face any direction, like GeoGuessr does by default.
+	HeadingRandom HeadingMode = "random"
+
+	// Face the side of the road with the most going on, i.e., towards the nearest junction or side
+	// road, where signs and buildings are more likely to be.
+	HeadingInformative HeadingMode = "informative"
+)
+
+// The bearing of a road is found by snapping points this many meters around the panorama to it.
+const bearingRadius = 20.0
+
+// Side roads are looked for by snapping points this many meters around the panorama, and count if
+// they are at least sideRoadOffset meters from the road through the panorama.
+const (
+	sideRoadRadius = 150.0
+	sideRoadOffset = 40.0
+)
+
+func ParseHeadingMode(s string) (HeadingMode, error) {
+	switch mode := HeadingMode(s); mode {
+	case HeadingAlongRoad, HeadingRandom, HeadingInformative:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown heading mode %q, expected road, random or informative", s)
+}
+
+// Returns the heading in degrees that each panorama should start at. Panoramas without a known road
+// bearing are snapped to the road around them, all in as few Roads API requests as possible.
+// Panoramas that still can't be given a bearing start at a random heading.
+func Headings(ctx context.Context, panos []googlemaps.Panorama, mode HeadingMode, sv *googlemaps.GoogleMapsClient) ([]float64, error) {
+	headings := make([]float64, len(panos))
+	if mode == HeadingRandom {
+		for i := range headings {
+			headings[i] = rand.Float64() * 360
+		}
+		return headings, nil
+	}
+
+	// Each panorama is followed by the points around it that it needs snapped.
+	points := make([][2]float64, 0)
+	starts := make([]int, len(panos))
+	for i, pano := range panos {
+		starts[i] = len(points)
+		if !pano.HasBearing {
+			points = append(points, ring(pano.Location, bearingRadius, 4)...)
+		}
+		if mode == HeadingInformative {
+			points = append(points, ring(pano.Location, sideRoadRadius, 8)...)
+		}
+	}
+
+	var roads [][]googlemaps.RoadPoint
+	if len(points) > 0 {
+		var err error
+		roads, err = sv.NearestRoadsPrecise(ctx, points)
+		if err != nil {
+			return []float64{}, err
+		}
+	}
+
+	for i, pano := range panos {
+		next := starts[i]
+		snapped := func(n int) [][2]float64 {
+			found := make([][2]float64, 0)
+			for _, points := range roads[next : next+n] {
+				for _, point := range points {
+					found = append(found, point.Location)
+				}
+			}
+			next += n
+			return found
+		}
+
+		bearing, ok := pano.Bearing, pano.HasBearing
+		if !ok {
+			bearing, ok = axis(pano.Location, snapped(4))
+		}
+		if !ok {
+			headings[i] = rand.Float64() * 360
+			continue
+		}
+
+		// Either way along the road is as good as the other.
+		headings[i] = bearing
+		if rand.IntN(2) == 1 {
+			headings[i] = math.Mod(bearing+180, 360)
+		}
+
+		if mode == HeadingInformative {
+			if side, ok := sideRoad(pano.Location, bearing, snapped(8)); ok {
+				headings[i] = side
+			}
+		}
+	}
+
+	return headings, nil
+}
+
+// Returns n points evenly spaced on a circle of the given radius in meters around the center.
+func ring(center [2]float64, radius float64, n int) [][2]float64 {
+	points := make([][2]float64, 0, n)
+	for i := 0; i < n; i++ {
+		points = append(points, destination(center, radius, float64(i)*360/float64(n)))
+	}
+	return points
+}
+
+// Returns the bearing in degrees of the line that best fits the points around the center, which
+// is one of the two directions along it. Fails if the points don't follow any one direction.
+func axis(center [2]float64, points [][2]float64) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+
+	var mx, my float64
+	xs, ys := make([]float64, len(points)), make([]float64, len(points))
+	for i, point := range points {
+		xs[i], ys[i] = project(center, point)
+		mx += xs[i] / float64(len(points))
+		my += ys[i] / float64(len(points))
+	}
+
+	var sxx, syy, sxy float64
+	for i := range points {
+		dx, dy := xs[i]-mx, ys[i]-my
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+	if sxx+syy < 1 {
+		return 0, false
+	}
+
+	// Angle of the principal axis from east, turned into a bearing from north.
+	theta := 0.5 * math.Atan2(2*sxy, sxx-syy)
+	return math.Mod(90-theta*180/math.Pi+360, 180), true
+}
+
+// Returns the bearing in degrees from the center towards the roads that are well off the one
+// through it, if there are any.
+func sideRoad(center [2]float64, bearing float64, points [][2]float64) (float64, bool) {
+	// Unit vector along the road, as (east, north).
+	ux, uy := math.Sin(bearing*math.Pi/180), math.Cos(bearing*math.Pi/180)
+
+	var sx, sy float64
+	n := 0
+	for _, point := range points {
+		x, y := project(center, point)
+		if math.Abs(x*uy-y*ux) < sideRoadOffset {
+			continue
+		}
+		sx += x
+		sy += y
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return math.Mod(math.Atan2(sx, sy)*180/math.Pi+360, 360), true
+}
+
+// Initial bearing in degrees of the great circle from a to b.
+func initialBearing(a [2]float64, b [2]float64) float64 {
+	lat1, lat2 := a[0]*math.Pi/180, b[0]*math.Pi/180
+	dLong := (b[1] - a[1]) * math.Pi / 180
+
+	y := math.Sin(dLong) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLong)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
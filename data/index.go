@@ -0,0 +1,278 @@
+package data
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"georep/googlemaps"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Directory for the preprocessed boundary cache. Empty disables the cache.
+var CacheDir = defaultCacheDir()
+
+// Bump whenever the layout of Subdivision changes so that stale caches are rebuilt.
+const cacheVersion = 2
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "georep")
+}
+
+// A subdivision with its geometry parsed and bounding box computed. The raw coordinates of the
+// feature are dropped once parsed, so use Geometry rather than Feature.MultiPolygon.
+type Subdivision struct {
+	Feature  Feature
+	Geometry MultiPolygon
+	Min      [2]float64
+	Max      [2]float64
+
+	// Area of each part of Geometry in square meters, which random points are drawn in proportion to.
+	Areas []float64
+}
+
+// Every subdivision, indexed by name, code and location.
+type Index struct {
+	Subdivisions []Subdivision
+
+	byName map[[2]string]int
+	byCode map[string]int
+	tree   rtree
+}
+
+type boundaryCache struct {
+	Version      int
+	Source       string
+	Subdivisions []Subdivision
+}
+
+var (
+	indexOnce sync.Once
+	index     *Index
+	indexErr  error
+)
+
+// Returns the index for DataDir, loading it on first use.
+func getIndex() (*Index, error) {
+	indexOnce.Do(func() {
+		index, indexErr = LoadIndex(DataDir, CacheDir)
+	})
+	return index, indexErr
+}
+
+// Loads the subdivisions from the boundary cache if it is up to date, and from the shapefile in
+// dataDir otherwise, in which case the cache is rebuilt.
+func LoadIndex(dataDir string, cacheDir string) (*Index, error) {
+	source, err := shapefileVersion(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	cachePath := filepath.Join(cacheDir, shapefileName+".gob")
+	if cacheDir != "" {
+		subdivisions, err := readBoundaryCache(cachePath, source)
+		if err == nil {
+			return newIndex(subdivisions), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("ignoring boundary cache: %v\n", err)
+		}
+	}
+
+	geojson, err := readShapefile(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	subdivisions := make([]Subdivision, 0, len(geojson.Features))
+	for _, feature := range geojson.Features {
+		geometry, err := feature.MultiPolygon()
+		if err != nil {
+			return nil, fmt.Errorf("parsing geometry of %v: %v", feature.Properties.Adm1Code, err)
+		}
+
+		var bounds rect
+		for i, polygon := range geometry {
+			min, max := getBoundingBox(polygon.Outer)
+			if i == 0 {
+				bounds = rect{min: min, max: max}
+				continue
+			}
+			bounds = bounds.union(rect{min: min, max: max})
+		}
+
+		feature.Geometry.Coordinates = nil
+		subdivisions = append(subdivisions, Subdivision{
+			Feature:  feature,
+			Geometry: geometry,
+			Min:      bounds.min,
+			Max:      bounds.max,
+			Areas:    geometry.PartAreas(),
+		})
+	}
+
+	if cacheDir != "" {
+		err = writeBoundaryCache(cachePath, source, subdivisions)
+		if err != nil {
+			fmt.Printf("writing boundary cache: %v\n", err)
+		}
+	}
+
+	return newIndex(subdivisions), nil
+}
+
+func newIndex(subdivisions []Subdivision) *Index {
+	ix := &Index{
+		Subdivisions: subdivisions,
+		byName:       make(map[[2]string]int),
+		byCode:       make(map[string]int),
+	}
+
+	bounds := make([]rect, 0, len(subdivisions))
+	for i, subdivision := range subdivisions {
+		properties := subdivision.Feature.Properties
+		for _, name := range []string{properties.Name, properties.NameEn} {
+			if name != "" {
+				ix.byName[[2]string{properties.Admin, strings.ToLower(name)}] = i
+			}
+		}
+		for _, code := range []string{properties.Adm1Code, properties.Iso31662} {
+			if code != "" && code != "-99" {
+				ix.byCode[strings.ToUpper(code)] = i
+			}
+		}
+		bounds = append(bounds, rect{min: subdivision.Min, max: subdivision.Max})
+	}
+	ix.tree = newRTree(bounds)
+
+	return ix
+}
+
+// Finds a subdivision by its English or local name within a country.
+func (ix *Index) Find(country string, name string) (*Subdivision, bool) {
+	i, ok := ix.byName[[2]string{country, strings.ToLower(name)}]
+	if !ok {
+		return nil, false
+	}
+	return &ix.Subdivisions[i], true
+}
+
+// Finds a subdivision by its ISO 3166-2 code (e.g. BR-PR) or Natural Earth adm1_code.
+func (ix *Index) FindByCode(code string) (*Subdivision, bool) {
+	i, ok := ix.byCode[strings.ToUpper(code)]
+	if !ok {
+		return nil, false
+	}
+	return &ix.Subdivisions[i], true
+}
+
+// Returns the subdivision containing the (lat, long) point.
+func (ix *Index) Locate(point [2]float64) (*Subdivision, bool) {
+	found := -1
+	ix.tree.search(point, func(i int) {
+		if found == -1 && ix.Subdivisions[i].Geometry.Contains(point) {
+			found = i
+		}
+	})
+	if found == -1 {
+		return nil, false
+	}
+	return &ix.Subdivisions[found], true
+}
+
+// Returns the subdivision containing the (lat, long) point or, failing that, the nearest subdivision
+// within tolerance meters of it.
+func (ix *Index) Nearest(point [2]float64, tolerance float64) (*Subdivision, bool) {
+	if subdivision, ok := ix.Locate(point); ok {
+		return subdivision, true
+	}
+
+	// Widen the point into a box that contains every location within tolerance of it.
+	dLat := tolerance / googlemaps.EarthRadius * 180 / math.Pi
+	dLong := dLat / math.Max(math.Cos(point[0]*math.Pi/180), 0.01)
+	search := rect{
+		min: [2]float64{point[0] - dLat, point[1] - dLong},
+		max: [2]float64{point[0] + dLat, point[1] + dLong},
+	}
+
+	found, nearest := -1, tolerance
+	ix.tree.searchRect(search, func(i int) {
+		if distance := ix.Subdivisions[i].Geometry.Distance(point); distance <= nearest {
+			found, nearest = i, distance
+		}
+	})
+	if found == -1 {
+		return nil, false
+	}
+	return &ix.Subdivisions[found], true
+}
+
+// Identifies the shapefile by the size and modification time of its files, so that replacing it
+// invalidates the boundary cache.
+func shapefileVersion(dir string) (string, error) {
+	parts := make([]string, 0)
+	for _, ext := range []string{".shp", ".dbf"} {
+		info, err := os.Stat(filepath.Join(dir, shapefileName+ext))
+		if err != nil {
+			return "", fmt.Errorf("missing %s%s (download it from naturalearthdata.com): %v", shapefileName, ext, err)
+		}
+		parts = append(parts, fmt.Sprintf("%d@%d", info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func readBoundaryCache(path string, source string) ([]Subdivision, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cache boundaryCache
+	err = gob.NewDecoder(file).Decode(&cache)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", path, err)
+	}
+	if cache.Version != cacheVersion || cache.Source != source {
+		return nil, fmt.Errorf("%s is out of date", path)
+	}
+
+	return cache.Subdivisions, nil
+}
+
+func writeBoundaryCache(path string, source string, subdivisions []Subdivision) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating cache directory: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating %s: %v", tmp, err)
+	}
+
+	cache := boundaryCache{
+		Version:      cacheVersion,
+		Source:       source,
+		Subdivisions: subdivisions,
+	}
+	err = gob.NewEncoder(file).Encode(cache)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("encoding %s: %v", tmp, err)
+	}
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("closing %s: %v", tmp, err)
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -1,65 +1,94 @@
 package data
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"georep/googlemaps"
 	"math"
-	"math/rand/v2"
-	"os"
 )
 
 var NULL_LOCATION = [2]float64{0, 0}
 var NO_LOCATIONS = [][2]float64{{0, 0}}
 
-func generateRandomLocationsInSubdivision(country string, subdivision string) ([][2]float64, error) {
-	file, err := os.ReadFile("D:/geosr/data/geojson/ne_10m_admin_1_states_provinces.json")
+// Returns every first-order subdivision of the country.
+func GetSubdivisions(country string) ([]Feature, error) {
+	ix, err := getIndex()
 	if err != nil {
-		return NO_LOCATIONS, err
+		return []Feature{}, err
 	}
 
-	var subdivisions GeoJSON
-	err = json.Unmarshal(file, &subdivisions)
+	features := make([]Feature, 0)
+	for _, subdivision := range ix.Subdivisions {
+		if country == subdivision.Feature.Properties.Admin {
+			features = append(features, subdivision.Feature)
+		}
+	}
+
+	if len(features) == 0 {
+		return []Feature{}, fmt.Errorf("country %v has no subdivisions or likely does not exist", country)
+	}
+	return features, nil
+}
+
+// Finds a subdivision of the country by its English or local name, ignoring case.
+func FindSubdivision(country string, name string) (Feature, error) {
+	ix, err := getIndex()
 	if err != nil {
-		return NO_LOCATIONS, err
+		return Feature{}, err
 	}
 
-	for _, feature := range subdivisions.Features {
-		if country == feature.Properties.Admin && subdivision == feature.Properties.NameEn {
-			var polygons [][][2]float64
-			if feature.Geometry.Type == "Polygon" {
-				if err = json.Unmarshal(feature.Geometry.Coordinates, &polygons); err != nil {
-					return NO_LOCATIONS, err
-				}
-			} else if feature.Geometry.Type == "MultiPolygon" {
-				var multi [][][][2]float64
-				if err = json.Unmarshal(feature.Geometry.Coordinates, &multi); err != nil {
-					return NO_LOCATIONS, err
-				}
+	subdivision, ok := ix.Find(country, name)
+	if !ok {
+		return Feature{}, fmt.Errorf("subdivision %v likely does not exist in %v", name, country)
+	}
+	return subdivision.Feature, nil
+}
 
-				for _, polygon := range multi {
-					polygons = append(polygons, polygon...)
-				}
-			}
+// Finds a subdivision by its ISO 3166-2 code (e.g. BR-PR) or Natural Earth adm1_code.
+func FindSubdivisionByCode(code string) (Feature, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return Feature{}, err
+	}
 
-			// The GeoJSON is (long, lat) instead of (lat, long). Why?
-			actual := make([][2]float64, 0)
-			for _, polygon := range polygons {
-				for _, coordinate := range polygon {
-					actual = append(actual, [2]float64{coordinate[1], coordinate[0]})
-				}
-			}
+	subdivision, ok := ix.FindByCode(code)
+	if !ok {
+		return Feature{}, fmt.Errorf("no subdivision has the code %v", code)
+	}
+	return subdivision.Feature, nil
+}
 
-			locations := make([][2]float64, 0)
-			for i := 0; i < 100; i++ {
-				location := getRandomPointInPolygon(actual)
-				locations = append(locations, location)
-			}
-			return locations, nil
+func generateRandomLocationsInSubdivision(subdivision *Subdivision) [][2]float64 {
+	locations := make([][2]float64, 0)
+	for i := 0; i < 100; i++ {
+		if location, ok := subdivision.Geometry.RandomPoint(subdivision.Areas); ok {
+			locations = append(locations, location)
 		}
 	}
+	return locations
+}
 
-	return NO_LOCATIONS, fmt.Errorf("subdivision %v likely does not exist in %v", subdivision, country)
+// Snapping and coverage validation can move a location across the border of its subdivision, so
+// check that the subdivision it ends up in is still the one being drilled.
+func isInSubdivision(ix *Index, subdivision *Subdivision, location [2]float64, buffer float64) bool {
+	found, ok := ix.Nearest(location, coastalTolerance)
+	if !ok || found != subdivision {
+		return false
+	}
+	if buffer <= 0 {
+		return true
+	}
+	return subdivision.Geometry.Contains(location) && subdivision.Geometry.BoundaryDistance(location) >= buffer
+}
+
+// Reports whether the location is at least spacing meters from every chosen location.
+func isSpacedOut(location [2]float64, chosen [][2]float64, spacing float64) bool {
+	for _, other := range chosen {
+		if googlemaps.Distance(location, other) < spacing {
+			return false
+		}
+	}
+	return true
 }
 
 func isPointInPolygon(point [2]float64, polygon [][2]float64) bool {
@@ -100,44 +129,103 @@ func getBoundingBox(polygon [][2]float64) ([2]float64, [2]float64) {
 	return [2]float64{minX, minY}, [2]float64{maxX, maxY}
 }
 
-func getRandomPointInPolygon(polygon [][2]float64) [2]float64 {
-	min, max := getBoundingBox(polygon)
+// Returns count panoramas with valid coverage in the subdivision.
+func GetLocationsInSubdivision(ctx context.Context, country string, subdivision string, count int, opts Options, sv *googlemaps.GoogleMapsClient) ([]googlemaps.Panorama, error) {
+	ix, err := getIndex()
+	if err != nil {
+		return []googlemaps.Panorama{}, err
+	}
 
-	for {
-		x := min[0] + rand.Float64()*(max[0]-min[0])
-		y := min[1] + rand.Float64()*(max[1]-min[1])
-		point := [2]float64{x, y}
+	target, ok := ix.Find(country, subdivision)
+	if !ok {
+		return []googlemaps.Panorama{}, fmt.Errorf("subdivision %v likely does not exist in %v", subdivision, country)
+	}
+	area := target.Geometry.Area()
+	if len(target.Geometry) == 0 || area == 0 {
+		return []googlemaps.Panorama{}, fmt.Errorf("subdivision %v in %v has no area", subdivision, country)
+	}
 
-		if isPointInPolygon(point, polygon) {
-			return point
+	// Each location claims a disc of half the spacing around it, and those can't cover more than the
+	// subdivision. Spacings anywhere near this limit are rarely satisfiable along real roads either.
+	if claimed := math.Pi * math.Pow(opts.MinSpacing/2, 2) * float64(count); claimed > area {
+		return []googlemaps.Panorama{}, fmt.Errorf("%d locations can't be %.0f m apart in %v, %v", count, opts.MinSpacing, subdivision, country)
+	}
+
+	s := newSampler(target, opts.Overpass)
+	locations := make([]googlemaps.Panorama, 0)
+	chosen := make([][2]float64, 0)
+	seen := make(map[string]bool)
+	worth := func(location [2]float64) bool {
+		if !isInSubdivision(ix, target, location, opts.BorderBuffer) {
+			fmt.Println("snapped location is outside of subdivision")
+			return false
+		}
+		if !isSpacedOut(location, chosen, opts.MinSpacing) {
+			fmt.Println("snapped location is too close to another location")
+			return false
 		}
+		return true
 	}
-}
+	accept := func(pano googlemaps.Panorama) bool {
+		// The panorama can be a little way from the snapped location, so check it all over again.
+		if !isInSubdivision(ix, target, pano.Location, opts.BorderBuffer) {
+			fmt.Println("panorama is outside of subdivision")
+			return false
+		}
+		if seen[pano.Id] || !isSpacedOut(pano.Location, chosen, opts.MinSpacing) {
+			fmt.Println("panorama was already chosen or is too close to another location")
+			return false
+		}
 
-func GetLocationsInSubdivision(country string, subdivision string, count int, sv *googlemaps.GoogleMapsClient) ([][2]float64, error) {
-	locations := make([][2]float64, 0)
+		locations = append(locations, pano)
+		chosen = append(chosen, pano.Location)
+		seen[pano.Id] = true
+		s.found()
+		fmt.Printf("found valid location %d\n", len(locations))
+		return len(locations) == count
+	}
 
-	for len(locations) < count {
-		// Generate 100 locations within the polygon defined by the GeoJSON for this subdivision.
-		randomLocations, err := generateRandomLocationsInSubdivision(country, subdivision)
-		if err != nil {
-			return [][2]float64{}, err
+	for batch := 0; len(locations) < count; batch++ {
+		if batch == maxSubdivisionBatches {
+			fmt.Print(s.report)
+			return []googlemaps.Panorama{}, &NotEnoughLocationsError{
+				Country:     country,
+				Subdivision: subdivision,
+				Found:       len(locations),
+				Wanted:      count,
+				Report:      s.report,
+			}
 		}
 
+		// Draw 100 candidates within the polygon defined by the boundaries of this subdivision.
+		candidates, onRoads := s.next(ctx, batch)
+		fmt.Printf("drawing %d candidates from %s\n", len(candidates), s.strategy)
+
 		// Snapping will fail for locations that are over 300 meters away from a road, but at least
 		// one should work since our sample size is large.
-		snappedLocations, err := sv.NearestRoads(randomLocations)
-		if err != nil {
-			return [][2]float64{}, err
+		snappedLocations := candidates
+		if !onRoads {
+			roads, err := sv.NearestRoads(ctx, candidates)
+			if err != nil {
+				return []googlemaps.Panorama{}, err
+			}
+
+			snappedLocations = make([][2]float64, 0)
+			for _, points := range roads {
+				for _, point := range points {
+					snappedLocations = append(snappedLocations, point.Location)
+				}
+			}
 		}
 
-		// Except for when it fails anyway in subdivisions with a sparse road network (e.g., Roraima).
-		if len(snappedLocations) == 1 && snappedLocations[0] == [2]float64{0, 0} {
+		// Except for when it fails anyway in subdivisions with a sparse road network (e.g., Roraima),
+		// which is what the other strategies are for.
+		if len(snappedLocations) == 0 {
 			fmt.Println("no roads nearby")
 			continue
 		}
 
-		// TODO: Why are there non-unique locations?
+		// Two-way roads are snapped to once in each direction, at the same location.
 		uniqueLocations := make([][2]float64, 0)
 		set := make(map[[2]float64]bool)
 		for _, location := range snappedLocations {
@@ -146,27 +234,18 @@ func GetLocationsInSubdivision(country string, subdivision string, count int, sv
 			}
 			uniqueLocations = append(uniqueLocations, location)
 			set[location] = true
+			s.onRoad(location)
 		}
 
 		fmt.Printf("found %d snapped locations\n", len(uniqueLocations))
 
 		// There is no guarantee that valid Google Street View coverage exists at the snapped location.
-		for _, location := range uniqueLocations {
-			valid, err := sv.ValidateCoverage(location)
-			if err != nil {
-				return [][2]float64{}, err
-			}
-			if valid {
-				locations = append(locations, location)
-				fmt.Printf("found valid location %d\n", len(locations))
-			} else {
-				fmt.Println("nonexistent or invalid coverage at location")
-			}
-			if len(locations) == count {
-				break
-			}
+		err = validateCandidates(ctx, uniqueLocations, opts, sv, worth, accept)
+		if err != nil {
+			return []googlemaps.Panorama{}, err
 		}
 	}
 
+	fmt.Print(s.report)
 	return locations, nil
 }
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"georep/googlemaps"
+	"georep/overpass"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// Candidates are drawn at most this many times per location before giving up on the road.
+const roadAttemptsPerLocation = 20
+
+// A road as a set of polylines, with the cumulative distance along all of them at each vertex.
+type roadGeometry struct {
+	polylines [][][2]float64
+	distances [][]float64
+	length    float64
+}
+
+func newRoadGeometry(polylines [][]overpass.Latlong) roadGeometry {
+	road := roadGeometry{}
+	for _, polyline := range polylines {
+		points := make([][2]float64, 0, len(polyline))
+		distances := make([]float64, 0, len(polyline))
+		for i, node := range polyline {
+			point := [2]float64{node.Latitude, node.Longitude}
+			if i > 0 {
+				road.length += googlemaps.Distance(points[i-1], point)
+			}
+			points = append(points, point)
+			distances = append(distances, road.length)
+		}
+		road.polylines = append(road.polylines, points)
+		road.distances = append(road.distances, distances)
+	}
+	return road
+}
+
+// Returns the point the given distance along the road. Gaps between polylines take up no distance.
+func (r roadGeometry) pointAt(distance float64) [2]float64 {
+	for i, distances := range r.distances {
+		if distance > distances[len(distances)-1] && i < len(r.distances)-1 {
+			continue
+		}
+
+		j := sort.SearchFloat64s(distances, distance)
+		if j == 0 {
+			return r.polylines[i][0]
+		}
+		if j == len(distances) {
+			return r.polylines[i][j-1]
+		}
+
+		a, b := r.polylines[i][j-1], r.polylines[i][j]
+		t := (distance - distances[j-1]) / (distances[j] - distances[j-1])
+		return [2]float64{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}
+	}
+	return NULL_LOCATION
+}
+
+// Returns how far along the road the point on it nearest to the given one is, and the bearing in
+// degrees of the stretch of road there.
+func (r roadGeometry) locate(point [2]float64) (float64, float64) {
+	nearest, along, bearing := math.MaxFloat64, 0.0, 0.0
+	for i, polyline := range r.polylines {
+		for j := 1; j < len(polyline); j++ {
+			t, d := closestOnSegment(point, polyline[j-1], polyline[j])
+			if d < nearest {
+				nearest = d
+				along = r.distances[i][j-1] + t*(r.distances[i][j]-r.distances[i][j-1])
+				bearing = initialBearing(polyline[j-1], polyline[j])
+			}
+		}
+	}
+	return along, bearing
+}
+
+// Returns the bearing in degrees of the road through the panorama. OSM ways only have a node every
+// so often, so a short stretch of the road through the panorama is snapped with interpolation to
+// follow its bends. Falls back to the bearing of the nearest OSM segment if that finds nothing.
+func (r roadGeometry) bearingAt(ctx context.Context, sv *googlemaps.GoogleMapsClient, location [2]float64) (float64, error) {
+	along, bearing := r.locate(location)
+	path := [][2]float64{r.pointAt(along - bearingRadius), location, r.pointAt(along + bearingRadius)}
+
+	snapped, err := sv.SnapToRoads(ctx, path, true)
+	if err != nil {
+		return 0, err
+	}
+
+	// The points on either side of the panorama's own are the closest ones along the road.
+	for i, point := range snapped {
+		if point.OriginalIndex == 1 && i > 0 && i < len(snapped)-1 {
+			return initialBearing(snapped[i-1].Location, snapped[i+1].Location), nil
+		}
+	}
+	return bearing, nil
+}
+
+// Returns count panoramas with valid coverage on the road.
+func GetLocationsOnRoad(ctx context.Context, country string, road string, count int, opts Options, sv *googlemaps.GoogleMapsClient) ([]googlemaps.Panorama, error) {
+	polylines, err := opts.Overpass.GetRoad(ctx, country, road)
+	if err != nil {
+		return []googlemaps.Panorama{}, err
+	}
+
+	geometry := newRoadGeometry(polylines)
+	if geometry.length == 0 {
+		return []googlemaps.Panorama{}, fmt.Errorf("road %v in %v has no length", road, country)
+	}
+	fmt.Printf("found %.0f km of %v\n", geometry.length/1000, road)
+
+	// Split the road into equal stretches and look for one location in each, so that the drill covers
+	// the whole road instead of whichever part happens to have the most coverage.
+	stretch := geometry.length / float64(count)
+	locations := make([]googlemaps.Panorama, 0)
+	chosen := make([][2]float64, 0)
+	seen := make(map[string]bool)
+	worth := func(location [2]float64) bool {
+		return isSpacedOut(location, chosen, opts.MinSpacing)
+	}
+	accept := func(pano googlemaps.Panorama) bool {
+		if seen[pano.Id] || !isSpacedOut(pano.Location, chosen, opts.MinSpacing) {
+			fmt.Println("panorama was already chosen or is too close to another location")
+			return false
+		}
+
+		locations = append(locations, pano)
+		chosen = append(chosen, pano.Location)
+		seen[pano.Id] = true
+		fmt.Printf("found valid location %d\n", len(locations))
+		return true
+	}
+
+	for len(locations) < count {
+		start := float64(len(locations)) * stretch
+
+		// Stretches without coverage (long tunnels, unpaved sections, ...) fall back to the whole road.
+		candidates := make([][2]float64, 0, roadAttemptsPerLocation)
+		for attempt := 0; attempt < roadAttemptsPerLocation; attempt++ {
+			distance := rand.Float64() * geometry.length
+			if attempt < roadAttemptsPerLocation/2 {
+				distance = start + rand.Float64()*stretch
+			}
+			candidates = append(candidates, geometry.pointAt(distance))
+		}
+
+		// There is no guarantee that valid Google Street View coverage exists on the road.
+		found := len(locations)
+		err = validateCandidates(ctx, candidates, opts, sv, worth, accept)
+		if err != nil {
+			return []googlemaps.Panorama{}, err
+		}
+
+		if len(locations) == found {
+			return []googlemaps.Panorama{}, fmt.Errorf("found only %d of %d locations on %v in %v", len(locations), count, road, country)
+		}
+	}
+
+	for i := range locations {
+		locations[i].Bearing, err = geometry.bearingAt(ctx, sv, locations[i].Location)
+		if err != nil {
+			return []googlemaps.Panorama{}, err
+		}
+		locations[i].HasBearing = true
+	}
+
+	return locations, nil
+}
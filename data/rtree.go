@@ -0,0 +1,117 @@
+package data
+
+import (
+	"math"
+	"sort"
+)
+
+// Maximum number of children of each node in the R-tree.
+const rtreeFanout = 16
+
+type rect struct {
+	min [2]float64
+	max [2]float64
+}
+
+func (r rect) intersects(other rect) bool {
+	return r.min[0] <= other.max[0] && r.max[0] >= other.min[0] && r.min[1] <= other.max[1] && r.max[1] >= other.min[1]
+}
+
+func (r rect) center() [2]float64 {
+	return [2]float64{(r.min[0] + r.max[0]) / 2, (r.min[1] + r.max[1]) / 2}
+}
+
+func (r rect) union(other rect) rect {
+	return rect{
+		min: [2]float64{math.Min(r.min[0], other.min[0]), math.Min(r.min[1], other.min[1])},
+		max: [2]float64{math.Max(r.max[0], other.max[0]), math.Max(r.max[1], other.max[1])},
+	}
+}
+
+// Leaves hold the index of an item, internal nodes hold children.
+type rtreeNode struct {
+	bounds   rect
+	children []*rtreeNode
+	item     int
+}
+
+// A static R-tree, bulk loaded with Sort-Tile-Recursive since the boundaries never change once loaded.
+type rtree struct {
+	root *rtreeNode
+}
+
+func newRTree(bounds []rect) rtree {
+	if len(bounds) == 0 {
+		return rtree{}
+	}
+
+	level := make([]*rtreeNode, 0, len(bounds))
+	for i, b := range bounds {
+		level = append(level, &rtreeNode{bounds: b, item: i})
+	}
+	for len(level) > 1 {
+		level = packLevel(level)
+	}
+	return rtree{root: level[0]}
+}
+
+// Groups nodes into parents by slicing them into bands by latitude and then packing each band by
+// longitude, so that each parent covers a compact area.
+func packLevel(nodes []*rtreeNode) []*rtreeNode {
+	parents := int(math.Ceil(float64(len(nodes)) / rtreeFanout))
+	strips := int(math.Ceil(math.Sqrt(float64(parents))))
+	stripSize := strips * rtreeFanout
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].bounds.center()[0] < nodes[j].bounds.center()[0]
+	})
+
+	packed := make([]*rtreeNode, 0, parents)
+	for start := 0; start < len(nodes); start += stripSize {
+		strip := nodes[start:min(start+stripSize, len(nodes))]
+		sort.Slice(strip, func(i, j int) bool {
+			return strip[i].bounds.center()[1] < strip[j].bounds.center()[1]
+		})
+
+		for i := 0; i < len(strip); i += rtreeFanout {
+			children := strip[i:min(i+rtreeFanout, len(strip))]
+			parent := &rtreeNode{
+				bounds:   children[0].bounds,
+				children: append([]*rtreeNode{}, children...),
+				item:     -1,
+			}
+			for _, child := range children[1:] {
+				parent.bounds = parent.bounds.union(child.bounds)
+			}
+			packed = append(packed, parent)
+		}
+	}
+	return packed
+}
+
+// Calls fn with every item whose bounds contain the point.
+func (t rtree) search(point [2]float64, fn func(item int)) {
+	t.searchRect(rect{min: point, max: point}, fn)
+}
+
+// Calls fn with every item whose bounds intersect the rectangle.
+func (t rtree) searchRect(r rect, fn func(item int)) {
+	if t.root == nil {
+		return
+	}
+
+	stack := []*rtreeNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !node.bounds.intersects(r) {
+			continue
+		}
+		if node.children == nil {
+			fn(node.item)
+			continue
+		}
+		stack = append(stack, node.children...)
+	}
+}
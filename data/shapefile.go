@@ -0,0 +1,176 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jonas-p/go-shp"
+)
+
+// Directory containing the Natural Earth admin-1 shapefile (.shp, .shx and .dbf). Relative paths are
+// resolved against the working directory, so the default works when run from the repository root.
+var DataDir = filepath.Join("data", "shapefiles")
+
+const shapefileName = "ne_10m_admin_1_states_provinces"
+
+// Reads the admin-1 shapefile into the same model as the Natural Earth GeoJSON, which is generated
+// from it: coordinates are (long, lat) and properties are named after the .dbf columns.
+func readShapefile(dir string) (GeoJSON, error) {
+	path := filepath.Join(dir, shapefileName)
+	for _, ext := range []string{".shp", ".shx", ".dbf"} {
+		if _, err := os.Stat(path + ext); err != nil {
+			return GeoJSON{}, fmt.Errorf("missing %s%s (download it from naturalearthdata.com): %v", shapefileName, ext, err)
+		}
+	}
+
+	reader, err := shp.Open(path + ".shp")
+	if err != nil {
+		return GeoJSON{}, fmt.Errorf("opening shapefile: %v", err)
+	}
+	defer reader.Close()
+
+	columns := make(map[string]int)
+	for i, field := range reader.Fields() {
+		columns[strings.ToLower(field.String())] = i
+	}
+	if len(columns) == 0 {
+		return GeoJSON{}, fmt.Errorf("shapefile has no attributes")
+	}
+
+	subdivisions := GeoJSON{
+		Type:     "FeatureCollection",
+		Features: make([]Feature, 0, reader.AttributeCount()),
+	}
+	for reader.Next() {
+		row, shape := reader.Shape()
+		polygon, ok := shape.(*shp.Polygon)
+		if !ok {
+			continue
+		}
+
+		feature := Feature{Type: "Feature"}
+		err = setGeometry(&feature, polygon)
+		if err != nil {
+			return GeoJSON{}, fmt.Errorf("reading geometry of shape %d: %v", row, err)
+		}
+		err = setProperties(&feature, func(column int) string {
+			return reader.ReadAttribute(row, column)
+		}, columns)
+		if err != nil {
+			return GeoJSON{}, fmt.Errorf("reading attributes of shape %d: %v", row, err)
+		}
+
+		subdivisions.Features = append(subdivisions.Features, feature)
+	}
+	if reader.Err() != nil {
+		return GeoJSON{}, fmt.Errorf("reading shapefile: %v", reader.Err())
+	}
+
+	return subdivisions, nil
+}
+
+// Shapefiles store every ring of a feature in one list, with outer rings clockwise and holes
+// counterclockwise. Each hole belongs to the outer ring that contains it.
+func setGeometry(feature *Feature, polygon *shp.Polygon) error {
+	outers := make([][][2]float64, 0)
+	holes := make([][][2]float64, 0)
+	for i, start := range polygon.Parts {
+		end := polygon.NumPoints
+		if i < len(polygon.Parts)-1 {
+			end = polygon.Parts[i+1]
+		}
+
+		ring := make([][2]float64, 0, end-start)
+		for _, point := range polygon.Points[start:end] {
+			ring = append(ring, [2]float64{point.X, point.Y})
+		}
+
+		if signedArea(ring) <= 0 {
+			outers = append(outers, ring)
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	if len(outers) == 0 {
+		return fmt.Errorf("no outer rings")
+	}
+
+	polygons := make([][][][2]float64, 0, len(outers))
+	for _, outer := range outers {
+		polygons = append(polygons, [][][2]float64{outer})
+	}
+	for _, hole := range holes {
+		for i, outer := range outers {
+			if isPointInPolygon(hole[0], outer) {
+				polygons[i] = append(polygons[i], hole)
+				break
+			}
+		}
+	}
+
+	var coordinates any = polygons
+	feature.Geometry.Type = "MultiPolygon"
+	if len(polygons) == 1 {
+		coordinates = polygons[0]
+		feature.Geometry.Type = "Polygon"
+	}
+
+	raw, err := json.Marshal(coordinates)
+	if err != nil {
+		return fmt.Errorf("marshaling coordinates: %v", err)
+	}
+	feature.Geometry.Coordinates = raw
+
+	return nil
+}
+
+// Fills in each property from the .dbf column named by its JSON tag.
+func setProperties(feature *Feature, attribute func(column int) string, columns map[string]int) error {
+	properties := reflect.ValueOf(&feature.Properties).Elem()
+	for i := 0; i < properties.NumField(); i++ {
+		name := strings.ToLower(properties.Type().Field(i).Tag.Get("json"))
+		column, ok := columns[name]
+		if !ok {
+			continue
+		}
+
+		value := strings.TrimSpace(attribute(column))
+		if value == "" {
+			continue
+		}
+
+		field := properties.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			// Numeric columns are sometimes stored with a fractional part, e.g. "3.000000".
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("parsing %s: %v", name, err)
+			}
+			field.SetInt(int64(n))
+		case reflect.Float64:
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("parsing %s: %v", name, err)
+			}
+			field.SetFloat(n)
+		}
+	}
+	return nil
+}
+
+// Twice the signed area of a ring, which is negative when the ring is clockwise.
+func signedArea(ring [][2]float64) float64 {
+	area := 0.0
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		area += (ring[j][0] - ring[i][0]) * (ring[j][1] + ring[i][1])
+	}
+	return area
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/jonas-p/go-shp"
+)
+
+// Returns the (long, lat) ring around the box from sw to ne, clockwise like outer rings in
+// shapefiles, or counterclockwise like holes.
+func shpBox(sw [2]float64, ne [2]float64, clockwise bool) []shp.Point {
+	ring := []shp.Point{{X: sw[0], Y: sw[1]}, {X: sw[0], Y: ne[1]}, {X: ne[0], Y: ne[1]}, {X: ne[0], Y: sw[1]}, {X: sw[0], Y: sw[1]}}
+	if clockwise {
+		return ring
+	}
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+	return ring
+}
+
+// Returns a shapefile polygon with the rings in order.
+func shpPolygon(rings ...[]shp.Point) *shp.Polygon {
+	polygon := &shp.Polygon{}
+	for _, ring := range rings {
+		polygon.Parts = append(polygon.Parts, int32(len(polygon.Points)))
+		polygon.Points = append(polygon.Points, ring...)
+	}
+	polygon.NumParts = int32(len(polygon.Parts))
+	polygon.NumPoints = int32(len(polygon.Points))
+	return polygon
+}
+
+func TestSignedArea(t *testing.T) {
+	tests := []struct {
+		name     string
+		ring     []shp.Point
+		negative bool
+	}{
+		{"clockwise", shpBox([2]float64{0, 0}, [2]float64{1, 1}, true), true},
+		{"counterclockwise", shpBox([2]float64{0, 0}, [2]float64{1, 1}, false), false},
+	}
+
+	for _, test := range tests {
+		ring := make([][2]float64, 0, len(test.ring))
+		for _, point := range test.ring {
+			ring = append(ring, [2]float64{point.X, point.Y})
+		}
+		if area := signedArea(ring); (area < 0) != test.negative {
+			t.Errorf("%s: signedArea() = %v", test.name, area)
+		}
+	}
+}
+
+func TestSetGeometry(t *testing.T) {
+	tests := []struct {
+		name     string
+		polygon  *shp.Polygon
+		geometry string
+		holes    []int
+	}{
+		{
+			name:     "single ring",
+			polygon:  shpPolygon(shpBox([2]float64{0, 0}, [2]float64{1, 1}, true)),
+			geometry: "Polygon",
+			holes:    []int{0},
+		},
+		{
+			// The hole comes before the island it is in, and after the other one.
+			name: "island pair with a lake",
+			polygon: shpPolygon(
+				shpBox([2]float64{10, 0}, [2]float64{11, 1}, true),
+				shpBox([2]float64{0.5, 0.5}, [2]float64{1.5, 1.5}, false),
+				shpBox([2]float64{0, 0}, [2]float64{2, 2}, true),
+			),
+			geometry: "MultiPolygon",
+			holes:    []int{0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		var feature Feature
+		err := setGeometry(&feature, test.polygon)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if feature.Geometry.Type != test.geometry {
+			t.Errorf("%s: geometry type %s, want %s", test.name, feature.Geometry.Type, test.geometry)
+		}
+
+		geometry, err := feature.MultiPolygon()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(geometry) != len(test.holes) {
+			t.Fatalf("%s: %d parts, want %d", test.name, len(geometry), len(test.holes))
+		}
+		for i, polygon := range geometry {
+			if len(polygon.Holes) != test.holes[i] {
+				t.Errorf("%s: part %d has %d holes, want %d", test.name, i, len(polygon.Holes), test.holes[i])
+			}
+		}
+	}
+
+	// Holes without an outer ring aren't a polygon.
+	var feature Feature
+	if err := setGeometry(&feature, shpPolygon(shpBox([2]float64{0, 0}, [2]float64{1, 1}, false))); err == nil {
+		t.Errorf("expected an error for a polygon without outer rings")
+	}
+}
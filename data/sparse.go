@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"georep/googlemaps"
+	"georep/overpass"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// Batches of candidates drawn for a subdivision before giving up on it. Sparse road networks (e.g.,
+// Roraima or Nunavut) can fail to snap whole batches, so this is much more than is usually needed.
+const maxSubdivisionBatches = 25
+
+// Every strategy starts out uniform across the subdivision for this many batches, to learn where
+// the roads are before narrowing the search down.
+const uniformBatches = 2
+
+// Candidates near earlier road points are drawn at most this many meters away from them.
+const nearbyRadius = 5000.0
+
+// Side of the grid laid over the subdivision to find where its roads are.
+const gridSize = 8
+
+const (
+	strategyUniform = "uniform"
+	strategyNearby  = "near earlier roads"
+	strategyDense   = "road-dense areas"
+	strategyOSM     = "osm roads"
+)
+
+func (e *NotEnoughLocationsError) Error() string {
+	return fmt.Sprintf("found only %d of %d locations in %v, %v after %d batches of candidates", e.Found, e.Wanted, e.Subdivision, e.Country, e.Report.Batches())
+}
+
+// Returns the number of batches drawn by every strategy.
+func (r SearchReport) Batches() int {
+	total := 0
+	for _, attempts := range r {
+		total += attempts.Batches
+	}
+	return total
+}
+
+func (r SearchReport) String() string {
+	strategies := make([]string, 0, len(r))
+	for strategy := range r {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+
+	var b strings.Builder
+	for _, strategy := range strategies {
+		attempts := r[strategy]
+		fmt.Fprintf(&b, "%s: %d batches, %d candidates, %d on roads, %d locations\n", strategy, attempts.Batches, attempts.Candidates, attempts.OnRoads, attempts.Found)
+	}
+	return b.String()
+}
+
+// Draws batches of candidates for a subdivision, moving from uniform sampling towards wherever roads
+// have been found as it learns more about the subdivision.
+type sampler struct {
+	target *Subdivision
+	op     *overpass.OverpassClient
+	report SearchReport
+
+	// Strategy that drew the current batch.
+	strategy string
+
+	// Points that snapped to a road, and how many fell in each cell of the grid.
+	roads [][2]float64
+	cells map[[2]int]int
+
+	// Major roads from OpenStreetMap, fetched the first time they are needed.
+	osm       *roadGeometry
+	osmFailed bool
+}
+
+func newSampler(target *Subdivision, op *overpass.OverpassClient) *sampler {
+	return &sampler{
+		target: target,
+		op:     op,
+		report: make(SearchReport),
+		cells:  make(map[[2]int]int),
+	}
+}
+
+// Returns the candidates for the batch, and whether they are already on roads and don't need
+// snapping, which is the case for those from OpenStreetMap.
+func (s *sampler) next(ctx context.Context, batch int) ([][2]float64, bool) {
+	strategies := []string{strategyUniform}
+	if batch >= uniformBatches {
+		if len(s.roads) > 0 {
+			strategies = append(strategies, strategyNearby, strategyDense)
+		}
+		if s.op != nil && !s.osmFailed {
+			strategies = append(strategies, strategyOSM)
+		}
+	}
+	strategy := strategies[batch%len(strategies)]
+
+	var candidates [][2]float64
+	switch strategy {
+	case strategyNearby:
+		candidates = s.nearby()
+	case strategyDense:
+		candidates = s.dense()
+	case strategyOSM:
+		candidates = s.onOSMRoads(ctx)
+		if candidates == nil {
+			strategy = strategyUniform
+			candidates = generateRandomLocationsInSubdivision(s.target)
+		}
+	default:
+		candidates = generateRandomLocationsInSubdivision(s.target)
+	}
+
+	attempts, ok := s.report[strategy]
+	if !ok {
+		attempts = &StrategyAttempts{}
+		s.report[strategy] = attempts
+	}
+	attempts.Batches++
+	attempts.Candidates += len(candidates)
+	s.strategy = strategy
+	return candidates, strategy == strategyOSM
+}
+
+// Remembers that there is a road at the point.
+func (s *sampler) onRoad(point [2]float64) {
+	s.report[s.strategy].OnRoads++
+	s.roads = append(s.roads, point)
+	s.cells[s.cell(point)]++
+}
+
+func (s *sampler) found() {
+	s.report[s.strategy].Found++
+}
+
+func (s *sampler) cell(point [2]float64) [2]int {
+	cell := [2]int{}
+	for i := range cell {
+		span := s.target.Max[i] - s.target.Min[i]
+		if span <= 0 {
+			continue
+		}
+		cell[i] = min(int((point[i]-s.target.Min[i])/span*gridSize), gridSize-1)
+	}
+	return cell
+}
+
+// Returns points within nearbyRadius of roads that have already been found.
+func (s *sampler) nearby() [][2]float64 {
+	return s.sample(func() [2]float64 {
+		road := s.roads[rand.IntN(len(s.roads))]
+		return destination(road, nearbyRadius*math.Sqrt(rand.Float64()), rand.Float64()*360)
+	})
+}
+
+// Returns points in cells of the grid, weighted by how many roads have been found in each.
+func (s *sampler) dense() [][2]float64 {
+	cells := make([][2]int, 0, len(s.cells))
+	for cell := range s.cells {
+		cells = append(cells, cell)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i][0] < cells[j][0] || (cells[i][0] == cells[j][0] && cells[i][1] < cells[j][1])
+	})
+
+	return s.sample(func() [2]float64 {
+		r := rand.IntN(len(s.roads))
+		cell := cells[len(cells)-1]
+		for _, c := range cells {
+			r -= s.cells[c]
+			if r < 0 {
+				cell = c
+				break
+			}
+		}
+
+		point := [2]float64{}
+		for i := range point {
+			size := (s.target.Max[i] - s.target.Min[i]) / gridSize
+			point[i] = s.target.Min[i] + (float64(cell[i])+rand.Float64())*size
+		}
+		return point
+	})
+}
+
+// Returns points along major roads in the subdivision, or nil if there are none.
+func (s *sampler) onOSMRoads(ctx context.Context) [][2]float64 {
+	if s.osm == nil && !s.osmFailed {
+		fmt.Println("looking up roads in OpenStreetMap")
+		sw := overpass.Latlong{Latitude: s.target.Min[0], Longitude: s.target.Min[1]}
+		ne := overpass.Latlong{Latitude: s.target.Max[0], Longitude: s.target.Max[1]}
+		polylines, err := s.op.GetRoadsInBox(ctx, sw, ne)
+		if err != nil {
+			fmt.Printf("getting roads from OpenStreetMap: %v\n", err)
+			s.osmFailed = true
+			return nil
+		}
+
+		geometry := newRoadGeometry(polylines)
+		if geometry.length == 0 {
+			s.osmFailed = true
+			return nil
+		}
+		s.osm = &geometry
+	}
+	if s.osm == nil {
+		return nil
+	}
+
+	candidates := s.sample(func() [2]float64 {
+		return s.osm.pointAt(rand.Float64() * s.osm.length)
+	})
+	if len(candidates) == 0 {
+		// The roads in the bounding box might all be outside of the subdivision itself.
+		s.osmFailed = true
+		return nil
+	}
+	return candidates
+}
+
+// Returns up to 100 points from draw that are in the subdivision. Points outside of it are drawn
+// again a few times before being given up on.
+func (s *sampler) sample(draw func() [2]float64) [][2]float64 {
+	candidates := make([][2]float64, 0)
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 10; j++ {
+			point := draw()
+			if s.target.Geometry.Contains(point) {
+				candidates = append(candidates, point)
+				break
+			}
+		}
+	}
+	return candidates
+}
+
+// Returns the point the given distance in meters from the start along the bearing in degrees.
+func destination(start [2]float64, distance float64, bearing float64) [2]float64 {
+	lat1, long1 := start[0]*math.Pi/180, start[1]*math.Pi/180
+	theta := bearing * math.Pi / 180
+	delta := distance / googlemaps.EarthRadius
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(theta))
+	long2 := long1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat1), math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+	long2 = math.Mod(long2+3*math.Pi, 2*math.Pi) - math.Pi
+	return [2]float64{lat2 * 180 / math.Pi, long2 * 180 / math.Pi}
+}
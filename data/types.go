@@ -1,6 +1,11 @@
 package data
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"georep/coverage"
+	"georep/googlemaps"
+	"georep/overpass"
+)
 
 type GeoJSON struct {
 	Type     string    `json:"type"`
@@ -137,3 +142,55 @@ type Feature struct {
 		FclassTlc  string  `json:"FCLASS_TLC"`
 	} `json:"properties"`
 }
+
+// A closed ring of (lat, long) coordinates.
+type Ring [][2]float64
+
+// Holes are the enclaves, lakes, ... within the outer ring that are not part of the polygon.
+type Polygon struct {
+	Outer Ring
+	Holes []Ring
+}
+
+// A subdivision made up of several disjoint parts, e.g. the islands of an Indonesian province.
+type MultiPolygon []Polygon
+
+// Constraints on the locations chosen for a drill.
+type Options struct {
+	// Locations must be at least this many meters inside the border of their subdivision.
+	BorderBuffer float64
+
+	// Locations must be at least this many meters apart, so that they don't cluster around one city.
+	MinSpacing float64
+
+	// Which panoramas count as valid coverage, e.g. only those captured after a date.
+	Coverage googlemaps.CoveragePolicy
+
+	// Where coverage is checked. Defaults to Street View through the Google Maps client.
+	Provider coverage.CoverageProvider
+
+	// Number of coverage checks to run at once. Zero or one checks them one at a time.
+	Workers int
+
+	// Used to find roads in subdivisions where snapping random points rarely works. Optional.
+	Overpass *overpass.OverpassClient
+}
+
+// Returned when a subdivision runs out of batches of candidates before enough locations are found.
+type NotEnoughLocationsError struct {
+	Country     string
+	Subdivision string
+	Found       int
+	Wanted      int
+	Report      SearchReport
+}
+
+// How many attempts each strategy for drawing candidates took, keyed by strategy.
+type SearchReport map[string]*StrategyAttempts
+
+type StrategyAttempts struct {
+	Batches    int
+	Candidates int
+	OnRoads    int
+	Found      int
+}
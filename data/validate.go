@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"georep/coverage"
+	"georep/googlemaps"
+	"sync"
+)
+
+type validation struct {
+	pano  googlemaps.Panorama
+	valid bool
+	err   error
+}
+
+// Checks the coverage at each candidate across opts.Workers workers, passing every valid panorama to
+// accept until it reports that it has enough. Candidates are skipped without a check unless worth
+// approves of them just before they are sent to a worker. Both callbacks are only ever called from
+// the calling goroutine, so they can share state without locking.
+func validateCandidates(ctx context.Context, candidates [][2]float64, opts Options, sv *googlemaps.GoogleMapsClient, worth func([2]float64) bool, accept func(googlemaps.Panorama) bool) error {
+	var provider coverage.CoverageProvider = sv
+	if opts.Provider != nil {
+		provider = opts.Provider
+	}
+
+	// Checks still in flight once there are enough locations are canceled rather than waited for.
+	ctx, cancel := context.WithCancel(ctx)
+
+	jobs := make(chan [2]float64)
+	results := make(chan validation)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(opts.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for location := range jobs {
+				pano, valid, err := provider.ValidateCoverage(ctx, location, opts.Coverage)
+				select {
+				case results <- validation{pano, valid, err}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	// Stop the workers once there are enough locations, canceling the checks in flight.
+	defer func() {
+		cancel()
+		close(done)
+		close(jobs)
+		wg.Wait()
+	}()
+
+	next, ready, inFlight := 0, false, 0
+	for {
+		for !ready && next < len(candidates) {
+			if worth(candidates[next]) {
+				ready = true
+			} else {
+				next++
+			}
+		}
+
+		// Sending on a nil channel blocks forever, so this only waits for results once every
+		// candidate has been sent.
+		var send chan [2]float64
+		var candidate [2]float64
+		if ready {
+			send = jobs
+			candidate = candidates[next]
+		} else if inFlight == 0 {
+			return nil
+		}
+
+		select {
+		case send <- candidate:
+			next++
+			ready = false
+			inFlight++
+		case result := <-results:
+			inFlight--
+			if result.err != nil {
+				return result.err
+			}
+			if !result.valid {
+				fmt.Println("nonexistent or invalid coverage at location")
+				continue
+			}
+			if accept(result.pano) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"georep/geoguessr"
+	"georep/store"
+	"log"
+)
+
+// Sets the rules and number of rounds the user drills with when they aren't given as flags, and
+// prints the defaults that are in effect.
+func setDefaults(args []string) {
+	var (
+		rounds      int
+		rulesPreset string
+		user        string
+	)
+
+	flags := flag.NewFlagSet("defaults", flag.ExitOnError)
+	flags.IntVar(&rounds, "rounds", 0, "default number of rounds, or 0 to reset it")
+	flags.StringVar(&rulesPreset, "rules", "", "default challenge rules: moving, no-move, nmpz, timed-60 or timed-30, or empty to reset them")
+	flags.StringVar(&user, "user", "", "user id")
+
+	flags.Parse(args)
+	if user == "" {
+		log.Fatalf("user must be specified")
+	}
+	if rounds != 0 {
+		err := geoguessr.ValidateRounds(rounds)
+		if err != nil {
+			log.Fatalf("checking -rounds: %v", err)
+		}
+	}
+	if rulesPreset != "" {
+		_, err := geoguessr.ParseRules(rulesPreset)
+		if err != nil {
+			log.Fatalf("parsing -rules: %v", err)
+		}
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("finding state directory: %v", err)
+	}
+
+	runs, err := store.Open(dir)
+	if err != nil {
+		log.Fatalf("opening store: %v", err)
+	}
+
+	// Only the flags that were given are changed.
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	defaults := runs.Defaults[user]
+	if set["rounds"] {
+		defaults.Rounds = rounds
+	}
+	if set["rules"] {
+		defaults.Rules = rulesPreset
+	}
+	if set["rounds"] || set["rules"] {
+		runs.SetDefaults(user, defaults)
+		err = runs.Save()
+		if err != nil {
+			log.Fatalf("saving defaults: %v", err)
+		}
+	}
+
+	if defaults.Rounds == 0 {
+		defaults.Rounds = defaultRounds
+	}
+	if defaults.Rules == "" {
+		defaults.Rules = geoguessr.DefaultPreset
+	}
+	fmt.Printf("%s drills %d rounds with %s rules\n", user, defaults.Rounds, defaults.Rules)
+}
@@ -1,10 +1,9 @@
 package geoguessr
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
+	"georep/internal/transport"
 	"log"
 	"net/http"
 	"net/http/cookiejar"
@@ -37,109 +36,60 @@ func NewGeoguessrClient() (*GeoguessrClient, error) {
 	jar.SetCookies(url, cookies)
 
 	return &GeoguessrClient{
-		client: &http.Client{Jar: jar},
+		client: transport.New(&http.Client{Jar: jar}),
 	}, nil
 }
 
-// Generates a new challenge link for the requested map.
-func (gc *GeoguessrClient) CreateChallenge(request CreateChallengeRequest) (string, error) {
-	payload, err := json.Marshal(request)
-	if err != nil {
-		return "", fmt.Errorf("marshaling request payload: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://www.geoguessr.com/api/v3/challenges", bytes.NewBuffer(payload))
-	if err != nil {
-		return "", fmt.Errorf("creating request: %v", err)
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := gc.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status from challenges API: %v", resp.StatusCode)
-	}
+// Returns the link to play a challenge on a map.
+func ChallengeLink(mapId string, token string) string {
+	return fmt.Sprintf("https://geoguessr.com/maps/%s/play?challengeId=%s", mapId, token)
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("reading response body: %v", err)
+// Generates a new challenge for the requested map and returns its token.
+func (gc *GeoguessrClient) CreateChallenge(ctx context.Context, request CreateChallengeRequest) (string, error) {
+	req := transport.Request{
+		Method: "POST",
+		URL:    "https://www.geoguessr.com/api/v3/challenges",
+		API:    "challenges API",
 	}
 
 	var response CreateChallengeResponse
-	err = json.Unmarshal(body, &response)
+	err := gc.client.DoJSON(ctx, req, request, &response)
 	if err != nil {
-		return "", fmt.Errorf("unmarshaling response body: %v", err)
+		return "", err
 	}
 
-	return fmt.Sprintf("https://geoguessr.com/maps/%s/play?challengeId=%s", request.Map, response.Token), nil
+	return response.Token, nil
 }
 
 // Returns the map ID of the new map.
-func (gc *GeoguessrClient) CreateMap(request CreateMapRequest) (string, error) {
-	payload, err := json.Marshal(request)
-	if err != nil {
-		return "", fmt.Errorf("marshaling request payload: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://www.geoguessr.com/api/v4/user-maps/drafts", bytes.NewBuffer(payload))
-	if err != nil {
-		return "", fmt.Errorf("creating request: %v", err)
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := gc.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status from drafts API: %v", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("reading response body: %v", err)
+func (gc *GeoguessrClient) CreateMap(ctx context.Context, request CreateMapRequest) (string, error) {
+	req := transport.Request{
+		Method: "POST",
+		URL:    "https://www.geoguessr.com/api/v4/user-maps/drafts",
+		API:    "drafts API",
 	}
 
 	var response CreateMapResponse
-	err = json.Unmarshal(body, &response)
+	err := gc.client.DoJSON(ctx, req, request, &response)
 	if err != nil {
-		return "", fmt.Errorf("unmarshaling response body: %v", err)
+		return "", err
 	}
 
 	return response.Id, nil
 }
 
-func (gc *GeoguessrClient) DeleteMap(request DeleteMapRequest) error {
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("https://www.geoguessr.com/api/v4/user-maps/%s", request.Id), http.NoBody)
-	if err != nil {
-		return fmt.Errorf("creating request: %v", err)
-	}
-
-	resp, err := gc.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status from user-maps API: %v", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+func (gc *GeoguessrClient) DeleteMap(ctx context.Context, request DeleteMapRequest) error {
+	req := transport.Request{
+		Method: "DELETE",
+		URL:    fmt.Sprintf("https://www.geoguessr.com/api/v4/user-maps/%s", request.Id),
+		API:    "user-maps API",
 	}
 
 	var response DeleteMapResponse
-	err = json.Unmarshal(body, &response)
+	err := gc.client.DoJSON(ctx, req, nil, &response)
 	if err != nil {
-		return fmt.Errorf("unmarshaling response body: %v", err)
+		return err
 	}
 
 	if !response.Deleted {
@@ -149,91 +99,50 @@ func (gc *GeoguessrClient) DeleteMap(request DeleteMapRequest) error {
 	return nil
 }
 
-func (gc *GeoguessrClient) GetChallengeResults(request GetChallengeResultsRequest) (*GetChallengeResultsResponse, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.geoguessr.com/api/v3/results/highscores/%s", request.Id), http.NoBody)
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %v", err)
+// Returns the highscores of a challenge, which include every guess made by each player.
+func (gc *GeoguessrClient) GetChallengeResults(ctx context.Context, request GetChallengeResultsRequest) (*GetChallengeResultsResponse, error) {
+	req := transport.Request{
+		Method: "GET",
+		URL:    fmt.Sprintf("https://www.geoguessr.com/api/v3/results/highscores/%s", request.Id),
+		API:    "results API",
 	}
 
-	resp, err := gc.client.Do(req)
+	var response GetChallengeResultsResponse
+	err := gc.client.DoJSON(ctx, req, nil, &response)
 	if err != nil {
-		return nil, fmt.Errorf("executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status from drafts API: %v", resp.StatusCode)
+		return nil, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response body: %v", err)
-	}
-
-	var response *GetChallengeResultsResponse
-	err = json.Unmarshal(body, response)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshaling response body: %v", err)
-	}
-
-	return response, nil
+	return &response, nil
 }
 
-func (gc *GeoguessrClient) ListMaps() ([]Map, error) {
-	req, err := http.NewRequest("GET", "https://www.geoguessr.com/api/v4/user-maps/maps", http.NoBody)
-	if err != nil {
-		return []Map{}, fmt.Errorf("creating request: %v", err)
-	}
-
-	resp, err := gc.client.Do(req)
-	if err != nil {
-		return []Map{}, fmt.Errorf("executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return []Map{}, fmt.Errorf("bad status from user-maps API: %v", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return []Map{}, fmt.Errorf("reading response body: %v", err)
+func (gc *GeoguessrClient) ListMaps(ctx context.Context) ([]Map, error) {
+	req := transport.Request{
+		Method: "GET",
+		URL:    "https://www.geoguessr.com/api/v4/user-maps/maps",
+		API:    "user-maps API",
 	}
 
 	var response []Map
-	err = json.Unmarshal(body, &response)
+	err := gc.client.DoJSON(ctx, req, nil, &response)
 	if err != nil {
-		return []Map{}, fmt.Errorf("unmarshaling response body: %v", err)
+		return []Map{}, err
 	}
 
 	return response, nil
 }
 
-func (gc *GeoguessrClient) PublishMap(request PublishMapRequest) error {
-	req, err := http.NewRequest("PUT", fmt.Sprintf("https://www.geoguessr.com/api/v4/user-maps/drafts/%s/publish", request.Id), http.NoBody)
-	if err != nil {
-		return fmt.Errorf("creating request: %v", err)
-	}
-
-	resp, err := gc.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status from drafts API: %v", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+func (gc *GeoguessrClient) PublishMap(ctx context.Context, request PublishMapRequest) error {
+	req := transport.Request{
+		Method: "PUT",
+		URL:    fmt.Sprintf("https://www.geoguessr.com/api/v4/user-maps/drafts/%s/publish", request.Id),
+		API:    "drafts API",
 	}
 
 	var response PublishMapResponse
-	err = json.Unmarshal(body, &response)
+	err := gc.client.DoJSON(ctx, req, nil, &response)
 	if err != nil {
-		return fmt.Errorf("unmarshaling response body: %v", err)
+		return err
 	}
 
 	if response.Message != "OK" {
@@ -243,37 +152,17 @@ func (gc *GeoguessrClient) PublishMap(request PublishMapRequest) error {
 	return nil
 }
 
-func (gc *GeoguessrClient) UpdateMap(request UpdateMapRequest, id string) error {
-	payload, err := json.Marshal(request)
-	if err != nil {
-		return fmt.Errorf("marshaling request payload: %v", err)
-	}
-
-	req, err := http.NewRequest("PUT", fmt.Sprintf("https://www.geoguessr.com/api/v4/user-maps/drafts/%s", id), bytes.NewBuffer(payload))
-	if err != nil {
-		return fmt.Errorf("creating request: %v", err)
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := gc.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("executing request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status from drafts API: %v ... %v", resp.StatusCode, string(body))
+func (gc *GeoguessrClient) UpdateMap(ctx context.Context, request UpdateMapRequest, id string) error {
+	req := transport.Request{
+		Method: "PUT",
+		URL:    fmt.Sprintf("https://www.geoguessr.com/api/v4/user-maps/drafts/%s", id),
+		API:    "drafts API",
 	}
 
 	var response UpdateMapResponse
-	err = json.Unmarshal(body, &response)
+	err := gc.client.DoJSON(ctx, req, request, &response)
 	if err != nil {
-		return fmt.Errorf("unmarshaling response body: %v", err)
+		return err
 	}
 
 	if response.Message != "OK" {
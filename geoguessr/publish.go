@@ -0,0 +1,270 @@
+package geoguessr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	StepCreate    PublishStep = "create map"
+	StepUpdate    PublishStep = "update map"
+	StepPublish   PublishStep = "publish map"
+	StepChallenge PublishStep = "create challenge"
+	StepJournal   PublishStep = "journal map"
+)
+
+// Maps that still can't be deleted after this many attempts at recovering them are dropped from the
+// journal, since they were most likely deleted some other way.
+const maxRecoveryAttempts = 5
+
+// Publishing a map takes seconds, so a map journaled longer ago than this was left behind by a run
+// that was interrupted, rather than being published by another run going on at the same time.
+const defaultStaleAfter = time.Hour
+
+func (e *PublishError) Error() string {
+	msg := fmt.Sprintf("failed to %s: %v", e.Step, e.Err)
+	if e.MapId != "" {
+		msg = fmt.Sprintf("failed to %s %s: %v", e.Step, e.MapId, e.Err)
+	}
+	if e.Rollback != nil {
+		msg += fmt.Sprintf(" (and failed to delete it again: %v)", e.Rollback)
+	}
+	return msg
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Opens the publisher with its journal in dir.
+func OpenPublisher(gc *GeoguessrClient, dir string) (*Publisher, error) {
+	p := &Publisher{
+		Client:           gc,
+		RollbackAttempts: 3,
+		RollbackDelay:    time.Second,
+		StaleAfter:       defaultStaleAfter,
+		path:             filepath.Join(dir, "publishing.json"),
+	}
+
+	_, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Deletes the maps left behind by runs that were interrupted while publishing. Maps journaled less
+// than StaleAfter ago are left alone, since another run may still be publishing them.
+func (p *Publisher) Recover(ctx context.Context) error {
+	journal, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	// Maps that were deleted or given up on, and maps that failed to be deleted this time.
+	done := make(map[string]bool)
+	failed := make(map[string]bool)
+	for _, entry := range journal {
+		if time.Since(entry.Started) < p.StaleAfter {
+			continue
+		}
+
+		err := p.Client.DeleteMap(ctx, DeleteMapRequest{Id: entry.MapId})
+		if err == nil {
+			log.Printf("deleted map %s left behind by an interrupted run", entry.MapId)
+			done[entry.MapId] = true
+			continue
+		}
+
+		if entry.Attempts+1 >= maxRecoveryAttempts {
+			log.Printf("giving up on deleting map %s: %v", entry.MapId, err)
+			done[entry.MapId] = true
+			continue
+		}
+		log.Printf("deleting map %s left behind by an interrupted run: %v", entry.MapId, err)
+		failed[entry.MapId] = true
+	}
+	if len(done) == 0 && len(failed) == 0 {
+		return nil
+	}
+
+	return p.update(func(journal []JournalEntry) []JournalEntry {
+		remaining := make([]JournalEntry, 0, len(journal))
+		for _, entry := range journal {
+			if done[entry.MapId] {
+				continue
+			}
+			if failed[entry.MapId] {
+				entry.Attempts++
+			}
+			remaining = append(remaining, entry)
+		}
+		return remaining
+	})
+}
+
+// Creates the map, fills it in, publishes it and creates its challenge. If any step fails, the map
+// is deleted again and a *PublishError is returned. Returns the map id and the challenge token.
+func (p *Publisher) Publish(ctx context.Context, pub Publication) (string, string, error) {
+	mapId, err := p.Client.CreateMap(ctx, pub.Map)
+	if err != nil {
+		return "", "", &PublishError{Step: StepCreate, Err: err}
+	}
+
+	// The map is journaled before anything else happens to it, so that it is deleted on the next
+	// start if this run doesn't get to finish.
+	err = p.update(func(journal []JournalEntry) []JournalEntry {
+		return append(journal, JournalEntry{MapId: mapId, Started: time.Now()})
+	})
+	if err != nil {
+		return "", "", p.rollback(ctx, StepJournal, mapId, err)
+	}
+
+	err = p.Client.UpdateMap(ctx, pub.Update, mapId)
+	if err != nil {
+		return "", "", p.rollback(ctx, StepUpdate, mapId, err)
+	}
+
+	err = p.Client.PublishMap(ctx, PublishMapRequest{Id: mapId})
+	if err != nil {
+		return "", "", p.rollback(ctx, StepPublish, mapId, err)
+	}
+
+	challenge := pub.Challenge
+	challenge.Map = mapId
+	token, err := p.Client.CreateChallenge(ctx, challenge)
+	if err != nil {
+		return "", "", p.rollback(ctx, StepChallenge, mapId, err)
+	}
+
+	// The map is done with, so failing to forget about it only means trying to delete it later.
+	err = p.forget(mapId)
+	if err != nil {
+		log.Printf("removing map %s from the journal: %v", mapId, err)
+	}
+
+	return mapId, token, nil
+}
+
+// Replaces the locations of an existing map, republishes it and creates a new challenge on it. The
+// map is never deleted, so a failure partway through can leave its draft ahead of what is published,
+// which the next successful republish catches up on. Returns the challenge token.
+func (p *Publisher) Republish(ctx context.Context, mapId string, pub Publication) (string, error) {
+	err := p.Client.UpdateMap(ctx, pub.Update, mapId)
+	if err != nil {
+		return "", &PublishError{Step: StepUpdate, MapId: mapId, Err: err}
+	}
+
+	err = p.Client.PublishMap(ctx, PublishMapRequest{Id: mapId})
+	if err != nil {
+		return "", &PublishError{Step: StepPublish, MapId: mapId, Err: err}
+	}
+
+	challenge := pub.Challenge
+	challenge.Map = mapId
+	token, err := p.Client.CreateChallenge(ctx, challenge)
+	if err != nil {
+		return "", &PublishError{Step: StepChallenge, MapId: mapId, Err: err}
+	}
+
+	return token, nil
+}
+
+// Deletes the map after a failed step, retrying with backoff. The map is only removed from the
+// journal once it is gone. Rolling back goes ahead even if the step failed because ctx was canceled.
+func (p *Publisher) rollback(ctx context.Context, step PublishStep, mapId string, err error) error {
+	publishErr := &PublishError{Step: step, MapId: mapId, Err: err}
+	ctx = context.WithoutCancel(ctx)
+
+	delay := p.RollbackDelay
+	for attempt := 0; attempt < max(p.RollbackAttempts, 1); attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		publishErr.Rollback = p.Client.DeleteMap(ctx, DeleteMapRequest{Id: mapId})
+		if publishErr.Rollback == nil {
+			break
+		}
+	}
+
+	if publishErr.Rollback == nil {
+		if err := p.forget(mapId); err != nil {
+			log.Printf("removing map %s from the journal: %v", mapId, err)
+		}
+	}
+	return publishErr
+}
+
+func (p *Publisher) forget(mapId string) error {
+	return p.update(func(journal []JournalEntry) []JournalEntry {
+		remaining := make([]JournalEntry, 0, len(journal))
+		for _, entry := range journal {
+			if entry.MapId != mapId {
+				remaining = append(remaining, entry)
+			}
+		}
+		return remaining
+	})
+}
+
+// Reads the journal as it is on disk, which other runs may have changed since it was last read.
+func (p *Publisher) load() ([]JournalEntry, error) {
+	journal := make([]JournalEntry, 0)
+
+	file, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading journal: %v", err)
+	}
+
+	err = json.Unmarshal(file, &journal)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling journal: %v", err)
+	}
+
+	return journal, nil
+}
+
+// Re-reads the journal and writes it back with the change applied, so that entries added or removed
+// by other runs in the meantime aren't lost. Runs racing between reading and writing can still lose
+// each other's changes, but the window is a few milliseconds rather than a whole run.
+func (p *Publisher) update(change func([]JournalEntry) []JournalEntry) error {
+	journal, err := p.load()
+	if err != nil {
+		return err
+	}
+	journal = change(journal)
+
+	err = os.MkdirAll(filepath.Dir(p.path), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating journal directory: %v", err)
+	}
+
+	payload, err := json.MarshalIndent(journal, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshaling journal: %v", err)
+	}
+
+	tmp := p.path + ".tmp"
+	err = os.WriteFile(tmp, payload, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	err = os.Rename(tmp, p.path)
+	if err != nil {
+		return fmt.Errorf("replacing journal: %v", err)
+	}
+
+	return nil
+}
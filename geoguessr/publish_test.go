@@ -0,0 +1,279 @@
+package geoguessr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"georep/internal/transport"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Sends every request to the test server instead of GeoGuessr.
+type redirect struct {
+	target *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// A stand-in for the parts of the GeoGuessr API that publishing uses. Steps in fail are answered
+// with a 400, and the first deleteFailures deletes with a 500.
+type fakeGeoguessr struct {
+	mu             sync.Mutex
+	fail           map[PublishStep]bool
+	deleteFailures int
+	calls          []string
+	maps           int
+}
+
+func (f *fakeGeoguessr) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := r.Method + " " + r.URL.Path
+	f.calls = append(f.calls, call)
+
+	step := PublishStep("")
+	response := `{"message":"OK"}`
+	switch {
+	case call == "POST /api/v4/user-maps/drafts":
+		f.maps++
+		step, response = StepCreate, fmt.Sprintf(`{"id":"map-%d"}`, f.maps)
+	case r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/publish"):
+		step = StepPublish
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/api/v4/user-maps/drafts/"):
+		step = StepUpdate
+	case call == "POST /api/v3/challenges":
+		step, response = StepChallenge, `{"token":"token"}`
+	case r.Method == "DELETE":
+		if f.deleteFailures > 0 {
+			f.deleteFailures--
+			http.Error(w, "try again", http.StatusInternalServerError)
+			return
+		}
+		response = `{"deleted":true}`
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if f.fail[step] {
+		http.Error(w, "nope", http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(response))
+}
+
+// Returns the number of deletes of the map so far.
+func (f *fakeGeoguessr) deletes(mapId string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, call := range f.calls {
+		if call == "DELETE /api/v4/user-maps/"+mapId {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns a publisher with its journal in a temporary directory that talks to the fake, without
+// retrying failed requests itself so that only the publisher's own retries are counted.
+func newTestPublisher(t *testing.T, fake *fakeGeoguessr) *Publisher {
+	t.Helper()
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := transport.New(&http.Client{Transport: redirect{target}})
+	client.MaxRetries = 0
+
+	p, err := OpenPublisher(&GeoguessrClient{client: client}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RollbackDelay = time.Millisecond
+	return p
+}
+
+// Returns the ids of the maps in the journal.
+func journaled(t *testing.T, p *Publisher) []string {
+	t.Helper()
+
+	journal, err := p.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(journal))
+	for _, entry := range journal {
+		ids = append(ids, entry.MapId)
+	}
+	return ids
+}
+
+func TestPublish(t *testing.T) {
+	fake := &fakeGeoguessr{}
+	p := newTestPublisher(t, fake)
+
+	mapId, token, err := p.Publish(context.Background(), Publication{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapId != "map-1" || token != "token" {
+		t.Errorf("got map %q and token %q", mapId, token)
+	}
+	if fake.deletes(mapId) != 0 {
+		t.Errorf("expected a published map not to be deleted")
+	}
+	if ids := journaled(t, p); len(ids) != 0 {
+		t.Errorf("expected an empty journal, got %v", ids)
+	}
+}
+
+func TestPublishRollsBack(t *testing.T) {
+	for _, step := range []PublishStep{StepUpdate, StepPublish, StepChallenge} {
+		t.Run(string(step), func(t *testing.T) {
+			fake := &fakeGeoguessr{fail: map[PublishStep]bool{step: true}}
+			p := newTestPublisher(t, fake)
+
+			_, _, err := p.Publish(context.Background(), Publication{})
+			var publishErr *PublishError
+			if !errors.As(err, &publishErr) {
+				t.Fatalf("expected a *PublishError, got %v", err)
+			}
+			if publishErr.Step != step || publishErr.MapId != "map-1" || publishErr.Rollback != nil {
+				t.Errorf("unexpected error %v", publishErr)
+			}
+			if !transport.IsStatus(err, http.StatusBadRequest) {
+				t.Errorf("expected the error of the failed step, got %v", err)
+			}
+			if n := fake.deletes("map-1"); n != 1 {
+				t.Errorf("expected the map to be deleted once, got %d deletes", n)
+			}
+			if ids := journaled(t, p); len(ids) != 0 {
+				t.Errorf("expected an empty journal, got %v", ids)
+			}
+		})
+	}
+}
+
+func TestCreateFailureNeedsNoRollback(t *testing.T) {
+	fake := &fakeGeoguessr{fail: map[PublishStep]bool{StepCreate: true}}
+	p := newTestPublisher(t, fake)
+
+	_, _, err := p.Publish(context.Background(), Publication{})
+	var publishErr *PublishError
+	if !errors.As(err, &publishErr) || publishErr.Step != StepCreate {
+		t.Fatalf("expected a create error, got %v", err)
+	}
+	if len(fake.calls) != 1 {
+		t.Errorf("expected nothing but the create, got %v", fake.calls)
+	}
+}
+
+func TestRollbackRetries(t *testing.T) {
+	fake := &fakeGeoguessr{fail: map[PublishStep]bool{StepPublish: true}, deleteFailures: 2}
+	p := newTestPublisher(t, fake)
+	p.RollbackAttempts = 3
+
+	_, _, err := p.Publish(context.Background(), Publication{})
+	var publishErr *PublishError
+	if !errors.As(err, &publishErr) || publishErr.Rollback != nil {
+		t.Fatalf("expected the rollback to succeed on the last attempt, got %v", err)
+	}
+	if n := fake.deletes("map-1"); n != 3 {
+		t.Errorf("expected 3 deletes, got %d", n)
+	}
+	if ids := journaled(t, p); len(ids) != 0 {
+		t.Errorf("expected an empty journal, got %v", ids)
+	}
+}
+
+func TestFailedRollbackStaysJournaled(t *testing.T) {
+	fake := &fakeGeoguessr{fail: map[PublishStep]bool{StepChallenge: true}, deleteFailures: 100}
+	p := newTestPublisher(t, fake)
+	p.RollbackAttempts = 2
+
+	_, _, err := p.Publish(context.Background(), Publication{})
+	var publishErr *PublishError
+	if !errors.As(err, &publishErr) || publishErr.Rollback == nil {
+		t.Fatalf("expected the rollback to fail, got %v", err)
+	}
+	if n := fake.deletes("map-1"); n != 2 {
+		t.Errorf("expected 2 deletes, got %d", n)
+	}
+	if ids := journaled(t, p); len(ids) != 1 || ids[0] != "map-1" {
+		t.Errorf("expected the map to stay in the journal, got %v", ids)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	fake := &fakeGeoguessr{}
+	p := newTestPublisher(t, fake)
+
+	now := time.Now()
+	err := p.update(func(journal []JournalEntry) []JournalEntry {
+		return []JournalEntry{
+			{MapId: "stale", Started: now.Add(-2 * p.StaleAfter)},
+			{MapId: "fresh", Started: now},
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Recover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.deletes("stale") != 1 || fake.deletes("fresh") != 0 {
+		t.Errorf("expected only the stale map to be deleted, got %v", fake.calls)
+	}
+	if ids := journaled(t, p); len(ids) != 1 || ids[0] != "fresh" {
+		t.Errorf("expected only the fresh map to stay in the journal, got %v", ids)
+	}
+}
+
+func TestRecoverGivesUp(t *testing.T) {
+	fake := &fakeGeoguessr{deleteFailures: 100}
+	p := newTestPublisher(t, fake)
+
+	started := time.Now().Add(-2 * p.StaleAfter)
+	err := p.update(func(journal []JournalEntry) []JournalEntry {
+		return []JournalEntry{
+			{MapId: "new", Started: started},
+			{MapId: "old", Started: started, Attempts: maxRecoveryAttempts - 1},
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Recover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := p.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal) != 1 || journal[0].MapId != "new" || journal[0].Attempts != 1 {
+		t.Errorf("expected the failed attempt to be counted and the old map given up on, got %+v", journal)
+	}
+}
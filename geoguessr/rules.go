@@ -0,0 +1,55 @@
+package geoguessr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const DefaultPreset = "no-move"
+
+// Challenges need at least one round. There is no upper bound beyond the locations on the map.
+const MinRounds = 1
+
+// Named rules that can be picked for a challenge.
+var Presets = map[string]Rules{
+	"moving":   {},
+	"no-move":  {NoMoving: true},
+	"nmpz":     {NoMoving: true, NoPanning: true, NoZooming: true},
+	"timed-60": {TimeLimit: 60},
+	"timed-30": {TimeLimit: 30},
+}
+
+func ParseRules(preset string) (Rules, error) {
+	rules, ok := Presets[strings.ToLower(preset)]
+	if !ok {
+		names := make([]string, 0, len(Presets))
+		for name := range Presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Rules{}, fmt.Errorf("unknown rules %q, expected one of %s", preset, strings.Join(names, ", "))
+	}
+	return rules, nil
+}
+
+// Returns an error unless a challenge can have this many rounds.
+func ValidateRounds(rounds int) error {
+	if rounds < MinRounds {
+		return fmt.Errorf("challenges have at least %d round, not %d", MinRounds, rounds)
+	}
+	return nil
+}
+
+// Returns a request for a challenge with these rules and the given number of rounds. The map is
+// filled in when the challenge is created.
+func (r Rules) Challenge(rounds int) CreateChallengeRequest {
+	return CreateChallengeRequest{
+		AccessLevel: 1,
+		NoMoving:    r.NoMoving,
+		NoPanning:   r.NoPanning,
+		NoZooming:   r.NoZooming,
+		TimeLimit:   r.TimeLimit,
+		RoundCount:  rounds,
+	}
+}
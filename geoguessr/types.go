@@ -1,12 +1,12 @@
 package geoguessr
 
 import (
-	"net/http"
+	"georep/internal/transport"
 	"time"
 )
 
 type GeoguessrClient struct {
-	client *http.Client
+	client *transport.Client
 }
 
 // Create a new challenge with these settings for a given map.
@@ -17,6 +17,15 @@ type CreateChallengeRequest struct {
 	NoZooming   bool   `json:"forbidZooming"`
 	Map         string `json:"map"`
 	TimeLimit   int    `json:"timeLimit"`
+	RoundCount  int    `json:"roundCount,omitempty"`
+}
+
+// How a challenge is played. TimeLimit is in seconds per round, or zero for no limit.
+type Rules struct {
+	NoMoving  bool
+	NoPanning bool
+	NoZooming bool
+	TimeLimit int
 }
 
 // Token is the challenge ID.
@@ -324,10 +333,12 @@ type Avatar struct {
 	Landscape  string `json:"landscape"`
 }
 
+// PanoId pins the location to a panorama. Otherwise, GeoGuessr picks the panorama nearest to it.
 type Location struct {
 	Heading   float64 `json:"heading"`
 	Latitude  float64 `json:"lat"`
 	Longitude float64 `json:"lng"`
+	PanoId    string  `json:"panoId,omitempty"`
 	Pitch     float64 `json:"pitch"`
 	Zoom      float64 `json:"zoom"`
 }
@@ -348,3 +359,44 @@ type UpdateMapRequest struct {
 type UpdateMapResponse struct {
 	Message string `json:"message"`
 }
+
+// Creates, fills in, publishes and challenges maps as one operation.
+type Publisher struct {
+	Client *GeoguessrClient
+
+	// How many times deleting a map is tried when rolling back, and how long to wait before the
+	// first retry. The wait doubles after each retry.
+	RollbackAttempts int
+	RollbackDelay    time.Duration
+
+	// Maps journaled at least this long ago are deleted when recovering.
+	StaleAfter time.Duration
+
+	path string
+}
+
+// A map that is being published. It is only removed from the journal once it has been published
+// and challenged, or deleted again.
+type JournalEntry struct {
+	MapId    string    `json:"mapId"`
+	Started  time.Time `json:"started"`
+	Attempts int       `json:"attempts,omitempty"`
+}
+
+// Everything needed to publish a drill. The map of the challenge is filled in by the publisher.
+type Publication struct {
+	Map       CreateMapRequest
+	Update    UpdateMapRequest
+	Challenge CreateChallengeRequest
+}
+
+type PublishStep string
+
+// Which step of publishing failed, the map it failed on if it got that far, and whether rolling it
+// back failed too. A map that couldn't be rolled back is left in the journal to be deleted later.
+type PublishError struct {
+	Step     PublishStep
+	MapId    string
+	Err      error
+	Rollback error
+}
@@ -3,6 +3,6 @@ module georep
 go 1.23.0
 
 require (
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/jonas-p/go-shp v0.1.1 // indirect
+	github.com/joho/godotenv v1.5.1
+	github.com/jonas-p/go-shp v0.1.1
 )
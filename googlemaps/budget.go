@@ -0,0 +1,158 @@
+package googlemaps
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Estimated price of a call to each endpoint in USD, from the pay-as-you-go rates. Street View
+// metadata requests are free, but are counted all the same.
+var Prices = map[string]float64{
+	"NearestRoads": 10.0 / 1000,
+	"SnapToRoads":  10.0 / 1000,
+	"Metadata":     0,
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s budget of $%.2f would be exceeded by calling %s ($%.2f spent)", e.Period, e.Limit, e.Endpoint, e.Spent)
+}
+
+// Opens the budget in dir, picking up what has already been spent this month.
+func OpenBudget(dir string, runLimit float64, monthLimit float64) (*Budget, error) {
+	b := &Budget{
+		RunLimit:   runLimit,
+		MonthLimit: monthLimit,
+		path:       filepath.Join(dir, "budget.json"),
+		month:      time.Now().Format("2006-01"),
+		runCalls:   make(map[string]int),
+		months:     make(map[string]float64),
+	}
+
+	months, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	b.months = months
+
+	return b, nil
+}
+
+// Records a call to the endpoint, or refuses it if it would exceed either cap. A nil budget allows
+// every call.
+func (b *Budget) Charge(endpoint string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	price := Prices[endpoint]
+	if b.RunLimit > 0 && b.runSpent+price > b.RunLimit {
+		return &BudgetExceededError{endpoint, "run", b.RunLimit, b.runSpent}
+	}
+	if b.MonthLimit > 0 && b.months[b.month]+price > b.MonthLimit {
+		return &BudgetExceededError{endpoint, "monthly", b.MonthLimit, b.months[b.month]}
+	}
+
+	b.runCalls[endpoint]++
+	if price == 0 {
+		return nil
+	}
+	b.runSpent += price
+	b.months[b.month] += price
+	b.unsaved += price
+
+	// Save after every paid call so that the monthly spend survives a crash.
+	return b.save()
+}
+
+// Reads the spend of each month as it is on disk, which other runs may have added to.
+func (b *Budget) load() (map[string]float64, error) {
+	months := make(map[string]float64)
+
+	file, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return months, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading budget: %v", err)
+	}
+
+	err = json.Unmarshal(file, &months)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling budget: %v", err)
+	}
+
+	return months, nil
+}
+
+// Adds what was spent since the last save to the file as it is now, so that runs going on at the
+// same time count each other's spending. The file isn't locked, so two runs saving at the same
+// instant can still lose one call's worth, and each run only sees the others' spending when it
+// saves, so together they can go over the monthly cap by what they spend in between.
+func (b *Budget) save() error {
+	months, err := b.load()
+	if err != nil {
+		return err
+	}
+	months[b.month] += b.unsaved
+
+	err = os.MkdirAll(filepath.Dir(b.path), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating budget directory: %v", err)
+	}
+
+	payload, err := json.MarshalIndent(months, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshaling budget: %v", err)
+	}
+
+	tmp := b.path + ".tmp"
+	err = os.WriteFile(tmp, payload, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing budget: %v", err)
+	}
+	err = os.Rename(tmp, b.path)
+	if err != nil {
+		return fmt.Errorf("replacing budget: %v", err)
+	}
+
+	b.months = months
+	b.unsaved = 0
+	return nil
+}
+
+// Returns the estimated cost of this run by endpoint, and the totals for the run and the month.
+func (b *Budget) Report() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	endpoints := make([]string, 0, len(b.runCalls))
+	for endpoint := range b.runCalls {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	var report strings.Builder
+	for _, endpoint := range endpoints {
+		calls := b.runCalls[endpoint]
+		fmt.Fprintf(&report, "%-12s %6d calls x $%.4f = $%.2f\n", endpoint, calls, Prices[endpoint], float64(calls)*Prices[endpoint])
+	}
+	fmt.Fprintf(&report, "estimated cost of this run: $%.2f%s\n", b.runSpent, limitSuffix(b.RunLimit))
+	fmt.Fprintf(&report, "estimated cost for %s: $%.2f%s\n", b.month, b.months[b.month], limitSuffix(b.MonthLimit))
+	return report.String()
+}
+
+func limitSuffix(limit float64) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" of $%.2f", limit)
+}
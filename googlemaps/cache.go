@@ -0,0 +1,208 @@
+package googlemaps
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	DefaultCacheTTL         = 180 * 24 * time.Hour
+	DefaultCacheNegativeTTL = 30 * 24 * time.Hour
+)
+
+// Coordinates are rounded to this many decimal places in cache keys. Metadata is looked up for
+// points already on a road, so ~10 m is close enough. Random points are snapped to roads up to
+// 300 m away, so ~100 m is close enough for those. Points that bearings are worked out from are only
+// 20 m from the panorama, so they need ~1 m.
+const (
+	metadataKeyPrecision       = 4
+	nearestKeyPrecision        = 3
+	preciseNearestKeyPrecision = 5
+)
+
+// Opens the cache in dir, dropping any expired entries.
+func OpenCache(dir string) (*Cache, error) {
+	c := &Cache{
+		TTL:         DefaultCacheTTL,
+		NegativeTTL: DefaultCacheNegativeTTL,
+		Hits:        make(map[string]int),
+		Misses:      make(map[string]int),
+		path:        filepath.Join(dir, "googlemaps.jsonl"),
+		entries:     make(map[string]cacheEntry),
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("creating cache directory: %v", err)
+	}
+
+	lines := 0
+	file, err := os.Open(c.path)
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			lines++
+
+			// A crash mid-write can leave a partial last line, which is simply skipped.
+			var entry cacheEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+				continue
+			}
+			if !c.expired(entry, time.Now()) {
+				c.entries[entry.Key] = entry
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading cache: %v", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("opening cache: %v", err)
+	}
+
+	// Rewrite the file without stale and overwritten entries once they make up most of it.
+	if lines > 2*len(c.entries) {
+		err = c.compact()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.file, err = os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening cache for writing: %v", err)
+	}
+
+	return c, nil
+}
+
+func (c *Cache) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
+
+func (c *Cache) compact() error {
+	tmp := c.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating %s: %v", tmp, err)
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range c.entries {
+		err = encoder.Encode(entry)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("encoding cache entry: %v", err)
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("writing %s: %v", tmp, err)
+	}
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("closing %s: %v", tmp, err)
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// Entries without results (ZERO_RESULTS, or no roads nearby) expire sooner, since new coverage and
+// roads do appear.
+func (c *Cache) expired(entry cacheEntry, now time.Time) bool {
+	ttl := c.TTL
+	if (entry.Metadata != nil && entry.Metadata.Status != "OK") || (entry.Metadata == nil && len(entry.Roads) == 0) {
+		ttl = c.NegativeTTL
+	}
+	return now.Sub(entry.Fetched) > ttl
+}
+
+func cacheKey(kind string, latlong [2]float64, precision int) string {
+	return fmt.Sprintf("%s:%.*f,%.*f", kind, precision, latlong[0], precision, latlong[1])
+}
+
+func (c *Cache) get(kind string, key string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && c.expired(entry, time.Now()) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if ok {
+		c.Hits[kind]++
+	} else {
+		c.Misses[kind]++
+	}
+	return entry, ok
+}
+
+// Failing to write to the cache only costs a repeated API call later, so errors are ignored.
+func (c *Cache) put(entry cacheEntry) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[entry.Key] = entry
+	if line, err := json.Marshal(entry); err == nil {
+		c.file.Write(append(line, '\n'))
+	}
+}
+
+func (c *Cache) getMetadata(latlong [2]float64) (GetMetadataResponse, bool) {
+	entry, ok := c.get("Metadata", cacheKey("metadata", latlong, metadataKeyPrecision))
+	if !ok || entry.Metadata == nil {
+		return GetMetadataResponse{}, false
+	}
+	return *entry.Metadata, true
+}
+
+// Only definitive answers are cached. Errors like OVER_QUERY_LIMIT are worth retrying.
+func (c *Cache) putMetadata(latlong [2]float64, response GetMetadataResponse) {
+	if response.Status != "OK" && response.Status != "ZERO_RESULTS" {
+		return
+	}
+	c.put(cacheEntry{
+		Key:      cacheKey("metadata", latlong, metadataKeyPrecision),
+		Fetched:  time.Now(),
+		Metadata: &response,
+	})
+}
+
+func (c *Cache) getNearest(latlong [2]float64, precision int) ([]RoadPoint, bool) {
+	entry, ok := c.get("NearestRoads", cacheKey("nearest", latlong, precision))
+	if !ok || entry.Metadata != nil {
+		return nil, false
+	}
+	return entry.Roads, true
+}
+
+func (c *Cache) putNearest(latlong [2]float64, precision int, roads []RoadPoint) {
+	c.put(cacheEntry{
+		Key:     cacheKey("nearest", latlong, precision),
+		Fetched: time.Now(),
+		Roads:   roads,
+	})
+}
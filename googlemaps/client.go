@@ -1,10 +1,9 @@
 package googlemaps
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
+	"georep/internal/transport"
 	"os"
 	"strings"
 )
@@ -16,111 +15,233 @@ func NewGoogleMapsClient() (*GoogleMapsClient, error) {
 	}
 
 	return &GoogleMapsClient{
-		Client:   http.DefaultClient,
+		Client:   transport.New(nil),
 		Auth:     key,
-		APICalls: make(map[string]int),
+		APICalls: NewCallCounter(),
 	}, nil
 }
 
-// Snap locations to the nearest road. A maximum of 100 locations will be used.
-func (gc *GoogleMapsClient) SnapToRoads(locations [][2]float64) ([][2]float64, error) {
-	if calls, ok := gc.APICalls["SnapToRoads"]; ok {
-		gc.APICalls["SnapToRoads"] = calls + 1
-	} else {
-		gc.APICalls["SnapToRoads"] = 1
-	}
+// The Roads API takes at most this many points per request.
+const roadsBatchSize = 100
+
+// Returns the points on the nearest roads to each location, which may be none or several. Roads
+// more than 300 meters away aren't found, and two-way roads are found once in each direction.
+func (gc *GoogleMapsClient) NearestRoads(ctx context.Context, locations [][2]float64) ([][]RoadPoint, error) {
+	return gc.nearestRoads(ctx, locations, nearestKeyPrecision)
+}
 
-	strs := make([]string, 0)
-	for _, location := range locations {
-		strs = append(strs, fmt.Sprintf("%f%%2C%f", location[0], location[1]))
+// Same as NearestRoads, but for points only meters apart whose answers mustn't be mixed up in the
+// cache, such as those around a panorama that its bearing is worked out from.
+func (gc *GoogleMapsClient) NearestRoadsPrecise(ctx context.Context, locations [][2]float64) ([][]RoadPoint, error) {
+	return gc.nearestRoads(ctx, locations, preciseNearestKeyPrecision)
+}
+
+func (gc *GoogleMapsClient) nearestRoads(ctx context.Context, locations [][2]float64, precision int) ([][]RoadPoint, error) {
+	// Only look up the locations that aren't already cached.
+	results := make([][]RoadPoint, len(locations))
+	missing := make([]int, 0)
+	for i, location := range locations {
+		if roads, ok := gc.Cache.getNearest(location, precision); ok {
+			// Cached points were snapped from an earlier lookup, so they are pointed at this one.
+			results[i] = make([]RoadPoint, len(roads))
+			for j, road := range roads {
+				road.OriginalIndex = i
+				results[i][j] = road
+			}
+		} else {
+			missing = append(missing, i)
+		}
 	}
-	path := strings.Join(strs, "%7C")
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://roads.googleapis.com/v1/nearestRoads?points=%s&key=%s", path, gc.Auth), http.NoBody)
-	if err != nil {
-		return [][2]float64{}, fmt.Errorf("creating request: %v", err)
+	for start := 0; start < len(missing); start += roadsBatchSize {
+		batch := missing[start:min(start+roadsBatchSize, len(missing))]
+		points := make([][2]float64, 0, len(batch))
+		for _, i := range batch {
+			points = append(points, locations[i])
+		}
+
+		response, err := gc.roads(ctx, "NearestRoads", fmt.Sprintf("https://roads.googleapis.com/v1/nearestRoads?points=%s&key=%s", joinPoints(points), gc.Auth))
+		if err != nil {
+			return [][]RoadPoint{}, err
+		}
+
+		fetched := make([][]RoadPoint, len(batch))
+		for _, point := range response.SnappedPoints {
+			if point.OriginalIndex == nil || *point.OriginalIndex < 0 || *point.OriginalIndex >= len(batch) {
+				continue
+			}
+			j := *point.OriginalIndex
+			fetched[j] = append(fetched[j], RoadPoint{
+				Location:      [2]float64{point.Location.Latitude, point.Location.Longitude},
+				PlaceId:       point.PlaceID,
+				OriginalIndex: batch[j],
+			})
+		}
+		for j, i := range batch {
+			results[i] = fetched[j]
+			gc.Cache.putNearest(locations[i], precision, fetched[j])
+		}
 	}
 
-	resp, err := gc.Client.Do(req)
-	if err != nil {
-		return [][2]float64{}, fmt.Errorf("executing request: %v", err)
+	return results, nil
+}
+
+// Snaps a path of consecutive points, such as a GPS track, to the roads it most likely followed.
+// With interpolate, points are added along the road between them so that the path follows its
+// curves. Paths longer than 100 points are snapped in overlapping pieces.
+func (gc *GoogleMapsClient) SnapToRoads(ctx context.Context, path [][2]float64, interpolate bool) ([]RoadPoint, error) {
+	snapped := make([]RoadPoint, 0)
+	for start := 0; start < len(path); start += roadsBatchSize - 1 {
+		end := min(start+roadsBatchSize, len(path))
+
+		response, err := gc.roads(ctx, "SnapToRoads", fmt.Sprintf("https://roads.googleapis.com/v1/snapToRoads?path=%s&interpolate=%t&key=%s", joinPoints(path[start:end]), interpolate, gc.Auth))
+		if err != nil {
+			return []RoadPoint{}, err
+		}
+
+		for _, point := range response.SnappedPoints {
+			index := -1
+			if point.OriginalIndex != nil {
+				// The first point of each piece was already snapped as the last point of the one before.
+				if start > 0 && *point.OriginalIndex == 0 {
+					continue
+				}
+				index = start + *point.OriginalIndex
+			}
+			snapped = append(snapped, RoadPoint{
+				Location:      [2]float64{point.Location.Latitude, point.Location.Longitude},
+				PlaceId:       point.PlaceID,
+				OriginalIndex: index,
+			})
+		}
+
+		if end == len(path) {
+			break
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return [][2]float64{}, fmt.Errorf("bad status from snap to roads API: %v", resp.StatusCode)
+	return snapped, nil
+}
+
+func joinPoints(points [][2]float64) string {
+	strs := make([]string, 0, len(points))
+	for _, point := range points {
+		strs = append(strs, fmt.Sprintf("%f%%2C%f", point[0], point[1]))
 	}
+	return strings.Join(strs, "%7C")
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (gc *GoogleMapsClient) roads(ctx context.Context, endpoint string, url string) (SnapToRoadsResponse, error) {
+	err := gc.Budget.Charge(endpoint)
 	if err != nil {
-		return [][2]float64{}, fmt.Errorf("reading response body: %v", err)
+		return SnapToRoadsResponse{}, err
+	}
+	err = gc.Limiter.Wait(ctx)
+	if err != nil {
+		return SnapToRoadsResponse{}, err
+	}
+	gc.APICalls.Add(endpoint)
+
+	req := transport.Request{
+		Method: "GET",
+		URL:    url,
+		API:    "roads API",
 	}
 
 	var response SnapToRoadsResponse
-	err = json.Unmarshal(body, &response)
+	err = gc.Client.DoJSON(ctx, req, nil, &response)
 	if err != nil {
-		return [][2]float64{}, fmt.Errorf("unmarshaling response: %v", err)
+		return SnapToRoadsResponse{}, err
 	}
+	return response, nil
+}
 
-	if len(response.SnappedPoints) == 0 {
-		return [][2]float64{{0, 0}}, nil
+// Returns whether the official panorama at the location was taken by car or by trekker, from whether
+// there is a road where it was taken.
+func (gc *GoogleMapsClient) carOrTrekker(ctx context.Context, location [2]float64) (CoverageType, error) {
+	roads, err := gc.NearestRoadsPrecise(ctx, [][2]float64{location})
+	if err != nil {
+		return CoverageUnknown, err
 	}
 
-	snapped := make([][2]float64, 0)
-	for _, point := range response.SnappedPoints {
-		p := [2]float64{
-			point.Location.Latitude,
-			point.Location.Longitude,
+	for _, road := range roads[0] {
+		if Distance(location, road.Location) <= offRoadDistance {
+			return CoverageCar, nil
 		}
-		snapped = append(snapped, p)
 	}
-
-	return snapped, nil
+	return CoverageTrekker, nil
 }
 
-// Locations should not be selected where there is no official Google Street View coverage.
-func (gc *GoogleMapsClient) ValidateCoverage(latlong [2]float64) (bool, error) {
-	if calls, ok := gc.APICalls["Metadata"]; ok {
-		gc.APICalls["Metadata"] = calls + 1
-	} else {
-		gc.APICalls["Metadata"] = 1
+// Locations should not be selected where there is no official Google Street View coverage, or where
+// the coverage doesn't meet the policy. Returns the panorama nearest to the location if it is valid.
+func (gc *GoogleMapsClient) ValidateCoverage(ctx context.Context, latlong [2]float64, policy CoveragePolicy) (Panorama, bool, error) {
+	response, err := gc.metadata(ctx, latlong)
+	if err != nil {
+		return Panorama{}, false, err
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://maps.googleapis.com/maps/api/streetview/metadata?location=%f,%%20%f&key=%s", latlong[0], latlong[1], gc.Auth), http.NoBody)
-	if err != nil {
-		return false, fmt.Errorf("creating request: %v", err)
+	// Will return ZERO_RESULTS if there is no coverage.
+	if response.Status != "OK" {
+		return Panorama{}, false, nil
 	}
 
-	resp, err := gc.Client.Do(req)
-	if err != nil {
-		return false, fmt.Errorf("executing request: %v", err)
+	if !policy.AllowsDate(response.Date) {
+		return Panorama{}, false, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("bad status from challenges API: %v", resp.StatusCode)
+	// Third-party coverage will not be copyright by Google, and is only allowed if the policy says so.
+	// Telling car coverage from trekker coverage costs a Roads API call, so it is only done when it
+	// matters.
+	location := [2]float64{response.Location.Lat, response.Location.Long}
+	coverageType := classify(response)
+	if coverageType == CoverageOfficial && policy.separatesOfficial() {
+		coverageType, err = gc.carOrTrekker(ctx, location)
+		if err != nil {
+			return Panorama{}, false, err
+		}
+	}
+	if !policy.AllowsType(coverageType) {
+		return Panorama{}, false, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return false, fmt.Errorf("reading response body: %v", err)
+	pano := Panorama{
+		Id:        response.PanoId,
+		Location:  location,
+		Date:      response.Date,
+		Copyright: response.Copyright,
+		Source:    "google",
+		Type:      coverageType,
+	}
+	return pano, true, nil
+}
+
+func (gc *GoogleMapsClient) metadata(ctx context.Context, latlong [2]float64) (GetMetadataResponse, error) {
+	if response, ok := gc.Cache.getMetadata(latlong); ok {
+		return response, nil
 	}
 
-	var response GetMetadataResponse
-	err = json.Unmarshal(body, &response)
+	err := gc.Budget.Charge("Metadata")
 	if err != nil {
-		return false, fmt.Errorf("unmarshaling response: %v", err)
+		return GetMetadataResponse{}, err
 	}
+	err = gc.Limiter.Wait(ctx)
+	if err != nil {
+		return GetMetadataResponse{}, err
+	}
+	gc.APICalls.Add("Metadata")
 
-	// Will return ZERO_RESULTS if there is no coverage.
-	if response.Status != "OK" {
-		return false, nil
+	req := transport.Request{
+		Method: "GET",
+		URL:    fmt.Sprintf("https://maps.googleapis.com/maps/api/streetview/metadata?location=%f,%%20%f&key=%s", latlong[0], latlong[1], gc.Auth),
+		API:    "street view metadata API",
 	}
 
-	// Third-party coverage will not be copyright by Google.
-	if response.Copyright != "© Google" {
-		return false, nil
+	var response GetMetadataResponse
+	err = gc.Client.DoJSON(ctx, req, nil, &response)
+	if err != nil {
+		return GetMetadataResponse{}, err
 	}
 
-	return true, nil
+	gc.Cache.putMetadata(latlong, response)
+	return response, nil
 }
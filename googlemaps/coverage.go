@@ -0,0 +1,139 @@
+package googlemaps
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// Taken by a Street View car, on a road.
+	CoverageCar CoverageType = "car"
+
+	// Taken by Google with a trekker backpack or on a tripod, usually away from roads (trails,
+	// national parks, inside buildings, ...).
+	CoverageTrekker CoverageType = "trekker"
+
+	// Taken by Google, by car or by trekker. The metadata API doesn't say which, so official
+	// panoramas are only told apart when the policy allows one but not the other.
+	CoverageOfficial CoverageType = "official"
+
+	// Photospheres uploaded by users, which aren't copyright by Google.
+	CoveragePhotosphere CoverageType = "photosphere"
+
+	// Anything else, e.g., panoramas without a copyright.
+	CoverageUnknown CoverageType = "unknown"
+)
+
+// Official panoramas have ids of this length. Those of photospheres are much longer.
+const officialPanoIdLength = 22
+
+// Ids of panoramas uploaded by users start with one of these, whatever their copyright says.
+var contributedPanoIdPrefixes = []string{"AF1Qip", "CIHM0og"}
+
+// Cars only drive on roads, so official panoramas with no road within this many meters of where they
+// were taken are taken to be trekker or tripod coverage. Roads are snapped to their centerline, so
+// this leaves room for wide roads.
+const offRoadDistance = 25.0
+
+// Parses a comma-separated list of coverage types, e.g. "car,trekker".
+func ParseCoverageTypes(types string) ([]CoverageType, error) {
+	parsed := make([]CoverageType, 0)
+	for _, t := range strings.Split(types, ",") {
+		switch t := CoverageType(strings.TrimSpace(t)); t {
+		case CoverageCar, CoverageTrekker, CoveragePhotosphere, CoverageUnknown:
+			parsed = append(parsed, t)
+		case "":
+		default:
+			return []CoverageType{}, fmt.Errorf("unknown coverage type %q, expected car, trekker, photosphere or unknown", t)
+		}
+	}
+	return parsed, nil
+}
+
+// Parses a capture date as returned by the metadata API ("2019-01"), or just a year ("2019").
+func ParseCaptureDate(date string) (time.Time, error) {
+	if t, err := time.Parse("2006-01", date); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006", date); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("capture date %q is not formatted as YYYY-MM", date)
+}
+
+// Reports whether a panorama captured on the date ("2019-01") is allowed. Both ends of the range are
+// inclusive, to the month. Panoramas without a capture date are only allowed when the range is open
+// at both ends.
+func (p CoveragePolicy) AllowsDate(date string) bool {
+	if p.CapturedAfter.IsZero() && p.CapturedBefore.IsZero() {
+		return true
+	}
+
+	captured, err := ParseCaptureDate(date)
+	if err != nil {
+		return false
+	}
+	if !p.CapturedAfter.IsZero() && captured.Before(p.CapturedAfter) {
+		return false
+	}
+	if !p.CapturedBefore.IsZero() && captured.After(p.CapturedBefore) {
+		return false
+	}
+	return true
+}
+
+// Reports whether coverage of the type is allowed. Without any types, car and trekker coverage are.
+// Official coverage that hasn't been told apart is allowed only if both of them are.
+func (p CoveragePolicy) AllowsType(t CoverageType) bool {
+	if t == CoverageOfficial {
+		return p.AllowsType(CoverageCar) && p.AllowsType(CoverageTrekker)
+	}
+	if len(p.Types) == 0 {
+		return t == CoverageCar || t == CoverageTrekker
+	}
+	for _, allowed := range p.Types {
+		if t == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether official panoramas have to be told apart into car and trekker coverage, because
+// the policy allows one but not the other.
+func (p CoveragePolicy) separatesOfficial() bool {
+	return p.AllowsType(CoverageCar) != p.AllowsType(CoverageTrekker)
+}
+
+// Classifies the panorama from its metadata: who holds the copyright and what its id looks like.
+func classify(response GetMetadataResponse) CoverageType {
+	for _, prefix := range contributedPanoIdPrefixes {
+		if strings.HasPrefix(response.PanoId, prefix) {
+			return CoveragePhotosphere
+		}
+	}
+
+	switch {
+	case response.Copyright == "© Google" && len(response.PanoId) == officialPanoIdLength:
+		return CoverageOfficial
+	case strings.HasPrefix(response.Copyright, "©") && response.Copyright != "© Google":
+		return CoveragePhotosphere
+	default:
+		return CoverageUnknown
+	}
+}
+
+// Mean radius of the Earth in meters, which every distance and area in georep is worked out with.
+const EarthRadius = 6371008.8
+
+// Great-circle distance in meters between two (lat, long) coordinates.
+func Distance(a [2]float64, b [2]float64) float64 {
+	lat1, lat2 := a[0]*math.Pi/180, b[0]*math.Pi/180
+	dLat := lat2 - lat1
+	dLong := (b[1] - a[1]) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(h))
+}
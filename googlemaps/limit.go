@@ -0,0 +1,69 @@
+package googlemaps
+
+import (
+	"context"
+	"time"
+)
+
+func NewCallCounter() *CallCounter {
+	return &CallCounter{
+		counts: make(map[string]int),
+	}
+}
+
+func (c *CallCounter) Add(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[endpoint]++
+}
+
+func (c *CallCounter) Total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	total := 0
+	for _, n := range c.counts {
+		total += n
+	}
+	return total
+}
+
+// The bucket starts full, so the first burst requests go through immediately.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:   perSecond,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		last:   time.Now(),
+	}
+}
+
+// Blocks until a request may be made, or ctx is done. A nil limiter never blocks.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil || l.rate <= 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	// Take the token now, even if it has yet to be refilled, so that waiting callers queue up in
+	// order instead of all waking at once.
+	l.tokens--
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
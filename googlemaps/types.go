@@ -1,12 +1,89 @@
 package googlemaps
 
-import "net/http"
+import (
+	"georep/internal/transport"
+	"os"
+	"sync"
+	"time"
+)
 
 type GoogleMapsClient struct {
-	Client *http.Client
+	Client *transport.Client
 	Auth   string
 
-	APICalls map[string]int
+	APICalls *CallCounter
+
+	// Responses are cached here when it is set.
+	Cache *Cache
+
+	// Requests wait for this when it is set, so that concurrent callers stay within quota.
+	Limiter *RateLimiter
+
+	// Requests are charged to this when it is set, and refused once it is spent.
+	Budget *Budget
+}
+
+// Caps on the estimated cost of API calls in USD, for this run and for the calendar month. The
+// monthly spend is persisted so that it accumulates across runs. Zero caps are unlimited.
+type Budget struct {
+	RunLimit   float64
+	MonthLimit float64
+
+	mu       sync.Mutex
+	path     string
+	month    string
+	runSpent float64
+	runCalls map[string]int
+	months   map[string]float64
+
+	// Spent since the budget was last saved, which is added to whatever the file says by then.
+	unsaved float64
+}
+
+// Returned instead of making a call that would take spending over a cap.
+type BudgetExceededError struct {
+	Endpoint string
+	Period   string
+	Limit    float64
+	Spent    float64
+}
+
+// Counts API calls by endpoint. Safe for concurrent use.
+type CallCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// A token bucket that allows bursts of up to Burst requests and Rate requests per second after that.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// A persistent cache of metadata and road snapping responses, keyed by rounded coordinates. Every
+// entry is appended to a file as it is fetched, so nothing is lost if a run is interrupted.
+type Cache struct {
+	// How long responses are kept, and how long responses without results are kept.
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	Hits   map[string]int
+	Misses map[string]int
+
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	Key      string               `json:"key"`
+	Fetched  time.Time            `json:"fetched"`
+	Metadata *GetMetadataResponse `json:"metadata,omitempty"`
+	Roads    []RoadPoint          `json:"roads,omitempty"`
 }
 
 type GetMetadataResponse struct {
@@ -26,7 +103,45 @@ type SnapToRoadsResponse struct {
 			Latitude  float64 `json:"latitude"`
 			Longitude float64 `json:"longitude"`
 		} `json:"location"`
-		OriginalIndex int    `json:"originalIndex,omitempty"`
+		OriginalIndex *int   `json:"originalIndex,omitempty"`
 		PlaceID       string `json:"placeId"`
 	} `json:"snappedPoints"`
 }
+
+// A point on a road, and the place id of the road segment it is on.
+type RoadPoint struct {
+	Location [2]float64 `json:"location"`
+	PlaceId  string     `json:"placeId"`
+
+	// Index of the point this was snapped from, or -1 if it was interpolated along a path.
+	OriginalIndex int `json:"-"`
+}
+
+// A Street View panorama found by the metadata API. Location is the exact position of the panorama,
+// which can be some distance from the location that was requested.
+type Panorama struct {
+	Id        string
+	Location  [2]float64
+	Date      string
+	Copyright string
+
+	// Coverage provider the panorama is from, e.g., "google" or "mapillary", and what kind of
+	// coverage it is if the provider can tell.
+	Source string
+	Type   CoverageType
+
+	// Bearing of the road through the panorama in degrees, if HasBearing.
+	Bearing    float64
+	HasBearing bool
+}
+
+// Which panoramas count as valid coverage. Zero capture dates leave that end of the range open.
+type CoveragePolicy struct {
+	CapturedAfter  time.Time
+	CapturedBefore time.Time
+
+	// Types of Street View coverage that are allowed. Official coverage of any kind if empty.
+	Types []CoverageType
+}
+
+type CoverageType string
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"georep/data"
+	"georep/geoguessr"
+	"georep/googlemaps"
+	"georep/schedule"
+	"georep/store"
+	"log"
+	"math"
+	"time"
+)
+
+// Grades a drill from the player's guesses in its challenge instead of asking them to self-report.
+func grade(ctx context.Context, args []string) {
+	var (
+		challenge string
+		player    string
+		user      string
+	)
+
+	flags := flag.NewFlagSet("grade", flag.ExitOnError)
+	flags.StringVar(&challenge, "challenge", "", "token of the challenge to grade")
+	flags.StringVar(&player, "player", "", "geoguessr nick or user id of the player, if several have played the challenge")
+	flags.StringVar(&user, "user", "", "user id")
+
+	flags.Parse(args)
+	if challenge == "" || user == "" {
+		log.Fatalf("challenge and user must be specified")
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("finding state directory: %v", err)
+	}
+
+	deck, err := schedule.LoadDeck(dir, user)
+	if err != nil {
+		log.Fatalf("loading deck for %s: %v", user, err)
+	}
+
+	runs, err := store.Open(dir)
+	if err != nil {
+		log.Fatalf("opening store: %v", err)
+	}
+
+	run, ok := runs.FindByToken(challenge)
+	if !ok || run.User != user {
+		log.Fatalf("%s has no drill for challenge %s", user, challenge)
+	}
+	if !run.Graded.IsZero() {
+		log.Fatalf("challenge %s was already graded on %s", challenge, run.Graded.Format(time.DateOnly))
+	}
+
+	gc, err := geoguessr.NewGeoguessrClient()
+	if err != nil {
+		log.Fatalf("creating geoguessr client: %v", err)
+	}
+
+	results, err := gc.GetChallengeResults(ctx, geoguessr.GetChallengeResultsRequest{Id: challenge})
+	if err != nil {
+		log.Fatalf("getting results for challenge %s: %v", challenge, err)
+	}
+
+	item := -1
+	for i, result := range results.Items {
+		if player == "" || result.PlayerName == player || result.UserID == player {
+			if item != -1 {
+				log.Fatalf("challenge %s has been played by several players, so one must be specified", challenge)
+			}
+			item = i
+		}
+	}
+	if item == -1 {
+		log.Fatalf("challenge %s has not been played by %s", challenge, player)
+	}
+
+	// Only the rounds that were played are graded. The subdivisions of any others stay due.
+	game := results.Items[item].Game
+	guesses := game.Player.Guesses
+	if len(guesses) == 0 {
+		log.Fatalf("no rounds of challenge %s have been played", challenge)
+	}
+	if len(guesses) != len(run.Locations) {
+		log.Printf("challenge %s was played for %d rounds, but the drill has %d locations", challenge, len(guesses), len(run.Locations))
+	}
+
+	// Every round is matched to a card before any is graded, so that a run is either graded in full or
+	// not at all.
+	now := time.Now()
+	cards := make([]*schedule.Card, len(guesses))
+	used := make([]bool, len(run.Locations))
+	for i := range guesses {
+		if i >= len(game.Rounds) {
+			break
+		}
+
+		// GeoGuessr doesn't necessarily play the locations of a map in the order they were added, so
+		// find the location each round was played at.
+		round := game.Rounds[i]
+		j := matchLocation(round.PanoID, [2]float64{round.Lat, round.Lng}, run.Locations, used)
+		if j == -1 {
+			log.Printf("round %d doesn't match any location of the drill", i+1)
+			continue
+		}
+		used[j] = true
+
+		card, err := roundCard(deck, run.Locations[j], now)
+		if err != nil {
+			log.Fatalf("finding the subdivision of round %d: %v", i+1, err)
+		}
+		cards[i] = card
+	}
+
+	// Subdivisions drilled more than once in the same challenge are graded by their worst round.
+	areas := make(map[string]map[string]float64)
+	grades := make(map[string]schedule.Grade)
+	for i, guess := range guesses {
+		card := cards[i]
+		if card == nil {
+			continue
+		}
+
+		if _, ok := areas[card.Country]; !ok {
+			areas[card.Country] = subdivisionAreas(card.Country)
+		}
+
+		var g schedule.Grade
+		if guess.SkippedRound || (guess.TimedOut && !guess.TimedOutWithGuess) {
+			g = schedule.GradeBlackout
+		} else if area := areas[card.Country][card.Adm1Code]; area > 0 {
+			g = schedule.GradeGuess(guess.DistanceInMeters, area)
+		} else {
+			g = schedule.GradeScore(guess.RoundScoreInPoints)
+		}
+
+		// Guessing anywhere in the right subdivision is what the drill is about, however far from the
+		// location the guess was.
+		if g < schedule.GradeCorrectHesitant && g != schedule.GradeBlackout {
+			feature, err := data.ReverseGeocode([2]float64{guess.Lat, guess.Lng})
+			if err == nil && feature.Properties.Adm1Code == card.Adm1Code {
+				g = schedule.GradeCorrectHesitant
+			}
+		}
+		log.Printf("round %d in %s, %s: %d points, %.1f km away, graded %d", i+1, card.Subdivision, card.Country, guess.RoundScoreInPoints, guess.DistanceInMeters/1000, g)
+
+		if prev, ok := grades[card.Adm1Code]; !ok || g < prev {
+			grades[card.Adm1Code] = g
+		}
+	}
+
+	for code, g := range grades {
+		card := deck.Cards[code]
+		card.Review(g, now)
+		log.Printf("%s, %s is next due on %s", card.Subdivision, card.Country, card.Due.Format(time.DateOnly))
+	}
+
+	err = deck.Save(dir)
+	if err != nil {
+		log.Fatalf("saving deck for %s: %v", user, err)
+	}
+
+	runs.SetGraded(run, now)
+	err = runs.Save()
+	if err != nil {
+		log.Fatalf("saving run: %v", err)
+	}
+}
+
+// Returns the user's card for the subdivision the location is in. Road drills don't record
+// subdivisions, so they are found from the location. Subdivisions the user isn't enrolled in are
+// added to their deck, so that every round counts.
+func roundCard(deck *schedule.Deck, location store.Location, now time.Time) (*schedule.Card, error) {
+	var feature data.Feature
+	var err error
+	if location.Adm1Code != "" {
+		if card, ok := deck.Cards[location.Adm1Code]; ok {
+			return card, nil
+		}
+		feature, err = data.FindSubdivisionByCode(location.Adm1Code)
+	} else {
+		feature, err = data.ReverseGeocode([2]float64{location.Latitude, location.Longitude})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if card, ok := deck.Cards[feature.Properties.Adm1Code]; ok {
+		return card, nil
+	}
+	card := schedule.NewCard(feature.Properties.Adm1Code, feature.Properties.Admin, feature.Properties.NameEn, now)
+	deck.Add(card)
+	log.Printf("enrolled in %s, %s, which the drill went to", card.Subdivision, card.Country)
+	return card, nil
+}
+
+// Returns the index of the unused location that a round was played at: the one with the round's
+// panorama, or else the nearest one. Returns -1 if every location has been used.
+func matchLocation(panoId string, latlong [2]float64, locations []store.Location, used []bool) int {
+	match, nearest := -1, math.MaxFloat64
+	for i, location := range locations {
+		if used[i] {
+			continue
+		}
+		if panoId != "" && location.PanoId == panoId {
+			return i
+		}
+		if d := googlemaps.Distance(latlong, [2]float64{location.Latitude, location.Longitude}); d < nearest {
+			match, nearest = i, d
+		}
+	}
+	return match
+}
+
+// Returns the area of each subdivision of a country, keyed by adm1_code. Grading falls back to the
+// round score if the boundaries can't be loaded, so failures are only logged.
+func subdivisionAreas(country string) map[string]float64 {
+	areas := make(map[string]float64)
+
+	features, err := data.GetSubdivisions(country)
+	if err != nil {
+		log.Printf("getting subdivisions of %s: %v", country, err)
+		return areas
+	}
+
+	for _, feature := range features {
+		areas[feature.Properties.Adm1Code] = float64(feature.Properties.AreaSqkm)
+	}
+	return areas
+}
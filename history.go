@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"georep/geoguessr"
+	"georep/store"
+	"log"
+	"strings"
+	"time"
+)
+
+// Lists the user's past runs, newest first.
+func history(args []string) {
+	var (
+		limit int
+		user  string
+	)
+
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	flags.IntVar(&limit, "limit", 10, "maximum number of runs to list")
+	flags.StringVar(&user, "user", "", "user id")
+
+	flags.Parse(args)
+	if user == "" {
+		log.Fatalf("user must be specified")
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("finding state directory: %v", err)
+	}
+
+	runs, err := store.Open(dir)
+	if err != nil {
+		log.Fatalf("opening store: %v", err)
+	}
+
+	for i, run := range runs.History(user) {
+		if i == limit {
+			break
+		}
+
+		graded := "ungraded"
+		if !run.Graded.IsZero() {
+			graded = "graded " + run.Graded.Format(time.DateOnly)
+		}
+
+		// Consecutive locations usually share a source, so only list each source once.
+		sources := make([]string, 0)
+		for _, location := range run.Locations {
+			source := location.Road
+			if location.Subdivision != "" {
+				source = location.Subdivision
+			}
+			source = fmt.Sprintf("%s, %s", source, location.Country)
+			if len(sources) == 0 || sources[len(sources)-1] != source {
+				sources = append(sources, source)
+			}
+		}
+
+		fmt.Printf("%s  %s  %s\n", run.Created.Format(time.DateTime), graded, strings.Join(sources, "; "))
+		fmt.Printf("\t%s\n", geoguessr.ChallengeLink(run.MapId, run.ChallengeToken))
+	}
+}
@@ -0,0 +1,218 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultTimeout    = 30 * time.Second
+	DefaultMaxRetries = 4
+	DefaultBaseDelay  = 500 * time.Millisecond
+	DefaultMaxDelay   = 30 * time.Second
+
+	// Long enough for per-minute rate limits, but not for daily quotas.
+	DefaultMaxRetryAfter = 2 * time.Minute
+)
+
+// Error bodies are cut off at this many bytes, since some APIs return whole HTML pages.
+const maxErrorBody = 2048
+
+// Returns a client with the default timeout and retries. A nil http client uses a new one.
+func New(client *http.Client) *Client {
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	return &Client{
+		HTTP:          client,
+		Timeout:       DefaultTimeout,
+		MaxRetries:    DefaultMaxRetries,
+		BaseDelay:     DefaultBaseDelay,
+		MaxDelay:      DefaultMaxDelay,
+		MaxRetryAfter: DefaultMaxRetryAfter,
+	}
+}
+
+func (e *APIError) Error() string {
+	body := e.Body
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	return fmt.Sprintf("bad status from %s: %d %s", e.API, e.StatusCode, bytes.TrimSpace(body))
+}
+
+// Reports whether err is an API error with the status code.
+func IsStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// Sends the request and returns the body of the response. Requests that were rate limited (429) are
+// retried, as are idempotent requests that failed with a 5xx status or didn't get a response at all.
+func (c *Client) Do(ctx context.Context, r Request) ([]byte, error) {
+	idempotent := r.Idempotent || r.Method == http.MethodGet || r.Method == http.MethodPut || r.Method == http.MethodDelete
+
+	delay := c.BaseDelay
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := c.attempt(ctx, r)
+		if err == nil {
+			return body, nil
+		}
+
+		retry := false
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			retry = apiErr.StatusCode == http.StatusTooManyRequests || (idempotent && apiErr.StatusCode >= 500)
+		} else {
+			retry = idempotent && ctx.Err() == nil
+		}
+		if !retry || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		// Honour the API's own idea of when to come back, unless that's longer than the caller is
+		// willing to wait. Otherwise back off with some jitter so that concurrent callers spread out.
+		wait := retryAfter
+		if wait > 0 {
+			if c.MaxRetryAfter > 0 && wait > c.MaxRetryAfter {
+				return nil, err
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+				return nil, err
+			}
+		} else {
+			wait = delay/2 + rand.N(delay/2+1)
+			if c.MaxDelay > 0 {
+				wait = min(wait, c.MaxDelay)
+			}
+		}
+		delay *= 2
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Sends the payload as JSON, if there is one, and unmarshals the response into response, if it
+// isn't nil.
+func (c *Client) DoJSON(ctx context.Context, r Request, payload any, response any) error {
+	if payload != nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("marshaling request payload: %v", err)
+		}
+		r.Body = body
+		r.ContentType = "application/json"
+	}
+
+	body, err := c.Do(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	if response == nil {
+		return nil
+	}
+	err = json.Unmarshal(body, response)
+	if err != nil {
+		return fmt.Errorf("unmarshaling response body: %v", err)
+	}
+	return nil
+}
+
+// Returns the body of a successful response, or an error and how long the API asked to be left
+// alone for, if it did.
+func (c *Client) attempt(ctx context.Context, r Request) ([]byte, time.Duration, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var reader io.Reader = http.NoBody
+	if r.Body != nil {
+		reader = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, reader)
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating request: %v", err)
+	}
+	if r.ContentType != "" {
+		req.Header.Set("Content-Type", r.ContentType)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("executing request: %w", redact(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading response body: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{
+			API:        r.API,
+			Method:     r.Method,
+			URL:        redactURL(r.URL),
+			StatusCode: resp.StatusCode,
+			Body:       body,
+		}
+		return nil, retryAfter(resp.Header.Get("Retry-After")), apiErr
+	}
+	return body, 0, nil
+}
+
+// Parses a Retry-After header, which is either a number of seconds or an HTTP date.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// API keys are passed in query strings, so they are kept out of errors that might be logged.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	query := u.Query()
+	for _, key := range []string{"key", "access_token"} {
+		if query.Has(key) {
+			query.Set(key, "REDACTED")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func redact(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns a server that answers with the status until it has been called fail times, then with 200,
+// and the number of times it has been called.
+func newFlakyServer(t *testing.T, fail int, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(calls.Add(1)) <= fail {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			http.Error(w, "try again", status)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// Returns a client that backs off for milliseconds rather than seconds.
+func newTestClient(srv *httptest.Server) *Client {
+	c := New(srv.Client())
+	c.BaseDelay = time.Millisecond
+	c.MaxDelay = 10 * time.Millisecond
+	return c
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv, calls := newFlakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	c := newTestClient(srv)
+
+	start := time.Now()
+	body, err := c.Do(context.Background(), Request{Method: http.MethodPost, URL: srv.URL, API: "test API"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"ok":true}` {
+		t.Errorf("unexpected body %s", body)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls, got %d", calls.Load())
+	}
+
+	// Retry-After isn't capped by MaxDelay, which only applies to the client's own backoff.
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After, waited %v", elapsed)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	srv, calls := newFlakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}})
+	c := newTestClient(srv)
+
+	start := time.Now()
+	_, err := c.Do(context.Background(), Request{Method: http.MethodGet, URL: srv.URL, API: "test API"})
+	if !IsStatus(err, http.StatusTooManyRequests) {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to fail without waiting, waited %v", elapsed)
+	}
+
+	// Nor is waiting past the deadline of the call.
+	srv, _ = newFlakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}})
+	c = newTestClient(srv)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = c.Do(ctx, Request{Method: http.MethodGet, URL: srv.URL, API: "test API"})
+	if !IsStatus(err, http.StatusTooManyRequests) {
+		t.Errorf("expected a 429 error, got %v", err)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   Request
+		calls int32
+		ok    bool
+	}{
+		{"get", Request{Method: http.MethodGet}, 3, true},
+		{"delete", Request{Method: http.MethodDelete}, 3, true},
+		{"idempotent post", Request{Method: http.MethodPost, Idempotent: true}, 3, true},
+		{"post", Request{Method: http.MethodPost}, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, calls := newFlakyServer(t, 2, http.StatusServiceUnavailable, nil)
+			c := newTestClient(srv)
+
+			test.req.URL = srv.URL
+			_, err := c.Do(context.Background(), test.req)
+			if test.ok && err != nil {
+				t.Errorf("expected the request to be retried until it succeeded, got %v", err)
+			}
+			if !test.ok && !IsStatus(err, http.StatusServiceUnavailable) {
+				t.Errorf("expected a 503 error, got %v", err)
+			}
+			if calls.Load() != test.calls {
+				t.Errorf("expected %d calls, got %d", test.calls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	srv, calls := newFlakyServer(t, 100, http.StatusInternalServerError, nil)
+	c := newTestClient(srv)
+	c.MaxRetries = 2
+
+	_, err := c.Do(context.Background(), Request{Method: http.MethodGet, URL: srv.URL})
+	if !IsStatus(err, http.StatusInternalServerError) {
+		t.Errorf("expected a 500 error, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestCancelDuringBackoff(t *testing.T) {
+	srv, calls := newFlakyServer(t, 100, http.StatusServiceUnavailable, nil)
+	c := newTestClient(srv)
+	c.BaseDelay = time.Minute
+	c.MaxDelay = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := c.Do(ctx, Request{Method: http.MethodGet, URL: srv.URL})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context's error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected to stop waiting once canceled, waited %v", elapsed)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"no such map"}`, http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	_, err := c.Do(context.Background(), Request{Method: http.MethodGet, URL: srv.URL + "/maps/abc?key=secret", API: "maps API"})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *APIError, got %v", err)
+	}
+	if apiErr.API != "maps API" || apiErr.Method != http.MethodGet || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected API %q, method %q or status %d", apiErr.API, apiErr.Method, apiErr.StatusCode)
+	}
+	if !strings.Contains(string(apiErr.Body), "no such map") {
+		t.Errorf("expected the response body, got %s", apiErr.Body)
+	}
+	if strings.Contains(apiErr.URL, "secret") || strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected the key to be redacted, got %s", apiErr.URL)
+	}
+	if !strings.HasPrefix(apiErr.URL, srv.URL+"/maps/abc?") {
+		t.Errorf("unexpected URL %s", apiErr.URL)
+	}
+}
@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"net/http"
+	"time"
+)
+
+// Sends requests to an API, retrying them when the API is overloaded or briefly unavailable.
+type Client struct {
+	HTTP *http.Client
+
+	// Each attempt at a request is given up on after this long. Zero means no timeout beyond the
+	// context of the call.
+	Timeout time.Duration
+
+	// Requests are retried at most this many times, waiting BaseDelay before the first retry and
+	// twice as long before each one after that, up to MaxDelay.
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	// Requests the API asks to be retried later than this (Retry-After) fail instead of waiting.
+	// Zero means waiting as long as the API asks, within the context of the call.
+	MaxRetryAfter time.Duration
+}
+
+type Request struct {
+	Method string
+	URL    string
+
+	// Optional body, sent with ContentType.
+	Body        []byte
+	ContentType string
+
+	// Name of the API for errors, e.g. "drafts API".
+	API string
+
+	// Whether the request can safely be sent again after the API failed partway through handling it.
+	// GET, PUT and DELETE requests always can.
+	Idempotent bool
+}
+
+// Returned for responses with a status other than 2xx, once any retries are exhausted.
+type APIError struct {
+	API        string
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
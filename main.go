@@ -1,43 +1,208 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"georep/coverage"
 	"georep/data"
 	"georep/geoguessr"
 	"georep/googlemaps"
+	"georep/overpass"
+	"georep/schedule"
+	"georep/store"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Drills have this many rounds unless the user or their defaults say otherwise.
+const defaultRounds = 5
+
 func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatalf("loading .env file: %v", err)
+	}
+
+	if dir, ok := os.LookupEnv("GEOREP_DATA_DIR"); ok {
+		data.DataDir = dir
+	}
+
+	// Interrupting a command cancels its requests, so that maps being published are rolled back.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "enroll":
+			enroll(os.Args[2:])
+			return
+		case "review":
+			review(os.Args[2:])
+			return
+		case "grade":
+			grade(ctx, os.Args[2:])
+			return
+		case "history":
+			history(os.Args[2:])
+			return
+		case "cleanup":
+			cleanup(ctx, os.Args[2:])
+			return
+		case "defaults":
+			setDefaults(os.Args[2:])
+			return
+		}
+	}
+	drill(ctx, os.Args[1:])
+}
+
+// Local state is kept in GEOREP_HOME, or in the user's config directory by default.
+func stateDir() (string, error) {
+	if dir, ok := os.LookupEnv("GEOREP_HOME"); ok {
+		return dir, nil
+	}
+
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("finding config directory: %v", err)
+	}
+	return filepath.Join(config, "georep"), nil
+}
+
+func drill(ctx context.Context, args []string) {
 	var (
-		country     string
-		road        string
-		subdivision string
-		user        string
+		borderBuffer   float64
+		country        string
+		coverageAfter  string
+		coverageBefore string
+		coverageSource string
+		coverageTypes  string
+		heading        string
+		maxMonthCost   float64
+		maxRunCost     float64
+		minSpacing     float64
+		persistentMap  bool
+		pitch          float64
+		rate           float64
+		road           string
+		rounds         int
+		rulesPreset    string
+		subdivision    string
+		user           string
+		workers        int
+		zoom           float64
 	)
 
-	flag.StringVar(&country, "country", "", "country containing the road")
-	flag.StringVar(&road, "road", "", "road within the country")
-	flag.StringVar(&subdivision, "subdivision", "", "first-order subdivision within the country")
-	flag.StringVar(&user, "user", "", "user id")
+	flags := flag.NewFlagSet("georep", flag.ExitOnError)
+	flags.Float64Var(&borderBuffer, "border-buffer", 0, "minimum distance in meters between each location and the border of its subdivision")
+	flags.StringVar(&country, "country", "", "country containing the road or subdivision, or to limit scheduled reviews to")
+	flags.StringVar(&coverageAfter, "coverage-after", "", "only use panoramas captured in or after this month (YYYY-MM)")
+	flags.StringVar(&coverageBefore, "coverage-before", "", "only use panoramas captured in or before this month (YYYY-MM)")
+	flags.StringVar(&coverageSource, "coverage-source", "google", "where to look for coverage: google (official Street View) or mapillary")
+	flags.StringVar(&coverageTypes, "coverage-types", "car,trekker", "kinds of Street View coverage to use: car, trekker, photosphere and/or unknown (allowing only one of car and trekker costs a Roads API call per panorama to tell them apart)")
+	flags.StringVar(&heading, "heading", "road", "which way each round starts facing: road, random or informative (towards side roads)")
+	flags.Float64Var(&maxMonthCost, "max-month-cost", 100, "maximum estimated Google Maps API cost this month in USD, or 0 for no limit")
+	flags.Float64Var(&maxRunCost, "max-run-cost", 2, "maximum estimated Google Maps API cost of this run in USD, or 0 for no limit")
+	flags.Float64Var(&minSpacing, "min-spacing", 0, "minimum distance in meters between any two locations in the same subdivision or on the same road")
+	flags.BoolVar(&persistentMap, "persistent-map", false, "reuse one long-lived map for every session of the user instead of creating a new one")
+	flags.Float64Var(&pitch, "pitch", 0, "starting pitch of each round in degrees, from -90 (down) to 90 (up)")
+	flags.Float64Var(&rate, "rate", 50, "maximum Google Maps API requests per second, or 0 for no limit")
+	flags.StringVar(&road, "road", "", "road within the country")
+	flags.IntVar(&rounds, "rounds", 0, fmt.Sprintf("number of rounds, or 0 for the user's default (%d if they have none)", defaultRounds))
+	flags.StringVar(&rulesPreset, "rules", "", fmt.Sprintf("challenge rules: moving, no-move, nmpz, timed-60 or timed-30, or empty for the user's default (%s if they have none)", geoguessr.DefaultPreset))
+	flags.StringVar(&subdivision, "subdivision", "", "first-order subdivision within the country")
+	flags.StringVar(&user, "user", "", "user id")
+	flags.IntVar(&workers, "workers", 8, "number of coverage checks to run at once")
+	flags.Float64Var(&zoom, "zoom", 0, "starting zoom of each round")
 
-	flag.Parse()
-	if country == "" || user == "" {
-		log.Fatalf("country and user must be specified")
+	flags.Parse(args)
+	if user == "" {
+		log.Fatalf("user must be specified")
+	}
+	if road != "" && subdivision != "" {
+		log.Fatalf("either a road or first-order subdivision may be specified, but not both")
 	}
-	if (road == "") == (subdivision == "") {
-		log.Fatalf("either a road or first-order subdivision must be specified, but not both")
+	if (road != "" || subdivision != "") && country == "" {
+		log.Fatalf("country must be specified with a road or first-order subdivision")
 	}
 
-	err := godotenv.Load()
+	dir, err := stateDir()
 	if err != nil {
-		log.Fatalf("loading .env file: %v", err)
+		log.Fatalf("finding state directory: %v", err)
+	}
+
+	var policy googlemaps.CoveragePolicy
+	if coverageAfter != "" {
+		policy.CapturedAfter, err = googlemaps.ParseCaptureDate(coverageAfter)
+		if err != nil {
+			log.Fatalf("parsing -coverage-after: %v", err)
+		}
+	}
+	if coverageBefore != "" {
+		policy.CapturedBefore, err = googlemaps.ParseCaptureDate(coverageBefore)
+		if err != nil {
+			log.Fatalf("parsing -coverage-before: %v", err)
+		}
+	}
+	policy.Types, err = googlemaps.ParseCoverageTypes(coverageTypes)
+	if err != nil {
+		log.Fatalf("parsing -coverage-types: %v", err)
+	}
+
+	headingMode, err := data.ParseHeadingMode(heading)
+	if err != nil {
+		log.Fatalf("parsing -heading: %v", err)
+	}
+
+	deck, err := schedule.LoadDeck(dir, user)
+	if err != nil {
+		log.Fatalf("loading deck for %s: %v", user, err)
+	}
+
+	runs, err := store.Open(dir)
+	if err != nil {
+		log.Fatalf("opening store: %v", err)
+	}
+
+	// Flags take precedence over the user's defaults, which take precedence over georep's.
+	defaults := runs.Defaults[user]
+	if rounds == 0 {
+		rounds = defaults.Rounds
+	}
+	if rounds == 0 {
+		rounds = defaultRounds
+	}
+	err = geoguessr.ValidateRounds(rounds)
+	if err != nil {
+		log.Fatalf("checking -rounds: %v", err)
+	}
+	if rulesPreset == "" {
+		rulesPreset = defaults.Rules
+	}
+	if rulesPreset == "" {
+		rulesPreset = geoguessr.DefaultPreset
+	}
+	rules, err := geoguessr.ParseRules(rulesPreset)
+	if err != nil {
+		log.Fatalf("parsing -rules: %v", err)
+	}
+
+	// Without a road or subdivision, drill whatever the user has due for review.
+	var due []*schedule.Card
+	if road == "" && subdivision == "" {
+		due = deck.Due(time.Now(), country, rounds)
+		if len(due) == 0 {
+			log.Printf("nothing is due for %s", user)
+			return
+		}
 	}
 
 	gc, err := geoguessr.NewGeoguessrClient()
@@ -45,148 +210,263 @@ func main() {
 		log.Fatalf("creating geoguessr client: %v", err)
 	}
 
-	// TODO: Obsolete?
-	// op, err := overpass.NewOverpassClient()
-	// if err != nil {
-	// 	log.Fatalf("creating overpass client: %v", err)
-	// }
-
 	sv, err := googlemaps.NewGoogleMapsClient()
 	if err != nil {
 		log.Fatalf("creating google maps client: %v", err)
 	}
+	if data.CacheDir != "" {
+		sv.Cache, err = googlemaps.OpenCache(data.CacheDir)
+		if err != nil {
+			log.Fatalf("opening google maps cache: %v", err)
+		}
+		defer sv.Cache.Close()
+	}
+	if rate > 0 {
+		sv.Limiter = googlemaps.NewRateLimiter(rate, workers)
+	}
+	sv.Budget, err = googlemaps.OpenBudget(dir, maxRunCost, maxMonthCost)
+	if err != nil {
+		log.Fatalf("opening google maps budget: %v", err)
+	}
 
-	err = deleteOldMaps(gc /* time.Duration(24 * time.Hour) */)
+	publisher, err := geoguessr.OpenPublisher(gc, dir)
+	if err != nil {
+		log.Fatalf("opening publisher: %v", err)
+	}
+	err = publisher.Recover(ctx)
 	if err != nil {
-		log.Fatalf("deleting old maps: %v", err)
+		log.Fatalf("recovering interrupted runs: %v", err)
 	}
 
-	date := strings.Split(time.Now().Format(time.RFC3339), "T")[0]
-	mapName := fmt.Sprintf("%s - %s", user, date)
+	err = cleanupMaps(ctx, gc, runs, defaultRetention, false)
+	if err != nil {
+		log.Fatalf("cleaning up maps: %v", err)
+	}
 
-	create := geoguessr.CreateMapRequest{
-		Mode: "coordinates",
-		Name: mapName,
+	var provider coverage.CoverageProvider = sv
+	switch coverageSource {
+	case "google":
+	case "mapillary":
+		if !policy.AllowsType(googlemaps.CoveragePhotosphere) {
+			log.Fatalf("mapillary coverage is all photospheres, so -coverage-types must include photosphere")
+		}
+		provider, err = coverage.NewMapillaryClient()
+		if err != nil {
+			log.Fatalf("creating mapillary client: %v", err)
+		}
+	default:
+		log.Fatalf("unknown coverage source %q", coverageSource)
 	}
-	mapId, err := gc.CreateMap(create)
+
+	op, err := overpass.NewOverpassClient()
 	if err != nil {
-		log.Fatalf("creating map: %v", err)
+		log.Fatalf("creating overpass client: %v", err)
+	}
+
+	opts := data.Options{
+		BorderBuffer: borderBuffer,
+		MinSpacing:   minSpacing,
+		Coverage:     policy,
+		Workers:      workers,
+		Provider:     provider,
+		Overpass:     op,
 	}
-	log.Printf(`created new map "%s" with id %s`, create.Name, mapId)
 
-	locations := make([][2]float64, 0)
+	// Where each location was drawn from, so that the run can be graded and reviewed later.
+	locations := make([]googlemaps.Panorama, 0)
+	sources := make([]store.Location, 0)
 	if road != "" {
-		// TODO: Use Google Directions API to get a polyline.
-		// locations = generateLocationsOnRoad()
-	} else {
-		locations, err = data.GetLocationsInSubdivision(country, subdivision, 5, sv)
+		locations, err = data.GetLocationsOnRoad(ctx, country, road, rounds, opts, sv)
+		if err != nil {
+			reportOverBudget(sv.Budget, err)
+			log.Fatalf("getting locations on %v, %v: %v", road, country, err)
+		}
+		for range locations {
+			sources = append(sources, store.Location{
+				Country: country,
+				Road:    road,
+			})
+		}
+	} else if subdivision != "" {
+		// Names are matched loosely, so the run records the subdivision as it is known to the deck.
+		feature, err := data.FindSubdivision(country, subdivision)
 		if err != nil {
+			log.Fatalf("finding subdivision: %v", err)
+		}
+		locations, err = data.GetLocationsInSubdivision(ctx, country, subdivision, rounds, opts, sv)
+		if err != nil {
+			reportOverBudget(sv.Budget, err)
 			log.Fatalf("getting locations in %v, %v: %v", subdivision, country, err)
 		}
-	}
+		source := store.Location{
+			Country:     country,
+			Subdivision: feature.Properties.NameEn,
+			Adm1Code:    feature.Properties.Adm1Code,
+		}
+		for range locations {
+			sources = append(sources, source)
+		}
+	} else {
+		for i, card := range due {
+			// Spread the rounds as evenly as possible over the due subdivisions.
+			n := rounds / len(due)
+			if i < rounds%len(due) {
+				n++
+			}
 
-	if len(locations) != 5 {
-		delete := geoguessr.DeleteMapRequest{
-			Id: mapId,
+			found, err := data.GetLocationsInSubdivision(ctx, card.Country, card.Subdivision, n, opts, sv)
+			if err != nil {
+				reportOverBudget(sv.Budget, err)
+				log.Fatalf("getting locations in %v, %v: %v", card.Subdivision, card.Country, err)
+			}
+			locations = append(locations, found...)
+			for range found {
+				sources = append(sources, store.Location{
+					Country:     card.Country,
+					Subdivision: card.Subdivision,
+					Adm1Code:    card.Adm1Code,
+				})
+			}
 		}
-		gc.DeleteMap(delete)
-		log.Fatalf("failed to find 5 locations")
+	}
+
+	if len(locations) != rounds {
+		log.Fatalf("failed to find %d locations", rounds)
+	}
+
+	headings, err := data.Headings(ctx, locations, headingMode, sv)
+	if err != nil {
+		reportOverBudget(sv.Budget, err)
+		log.Fatalf("finding headings: %v", err)
 	}
 
 	geoLocations := make([]geoguessr.Location, 0)
-	for _, location := range locations {
+	for i, location := range locations {
 		geoLocation := geoguessr.Location{
-			Heading:   0,
-			Latitude:  location[0],
-			Longitude: location[1],
-			Pitch:     0,
-			Zoom:      0,
+			Heading:   headings[i],
+			Latitude:  location.Location[0],
+			Longitude: location.Location[1],
+			Pitch:     pitch,
+			Zoom:      zoom,
+		}
+
+		// GeoGuessr only knows Street View panoramas, and finds the nearest one to the location for
+		// other sources.
+		if location.Source == "google" {
+			geoLocation.PanoId = location.Id
 		}
 		geoLocations = append(geoLocations, geoLocation)
 	}
 
-	update := geoguessr.UpdateMapRequest{
-		Avatar: geoguessr.Avatar{
-			Background: "day",
-			Decoration: "cactus",
-			Ground:     "green",
-			Landscape:  "mountains",
+	date := strings.Split(time.Now().Format(time.RFC3339), "T")[0]
+	mapName := fmt.Sprintf("%s - %s", user, date)
+	if persistentMap {
+		mapName = fmt.Sprintf("%s - georep", user)
+	}
+
+	publication := geoguessr.Publication{
+		Map: geoguessr.CreateMapRequest{
+			Mode: "coordinates",
+			Name: mapName,
 		},
-		Locations:   geoLocations,
-		Description: "",
-		Name:        mapName,
-		Regions:     []geoguessr.Region{},
+		Update: geoguessr.UpdateMapRequest{
+			Avatar: geoguessr.Avatar{
+				Background: "day",
+				Decoration: "cactus",
+				Ground:     "green",
+				Landscape:  "mountains",
+			},
+			Locations:   geoLocations,
+			Description: mapDescription(user),
+			Name:        mapName,
+			Regions:     []geoguessr.Region{},
+		},
+		Challenge: rules.Challenge(rounds),
 	}
 
-	err = gc.UpdateMap(update, mapId)
-	if err != nil {
-		delete := geoguessr.DeleteMapRequest{
-			Id: mapId,
+	// The map is only created once its locations have been found, and deleted again if it can't be
+	// published and challenged. Long-lived maps are created the first time and reused after that.
+	var mapId, token string
+	if id, ok := runs.PersistentMap(user); ok && persistentMap {
+		if mapExists(ctx, gc, id) {
+			mapId = id
+			token, err = publisher.Republish(ctx, mapId, publication)
+		} else {
+			log.Printf("map %s of %s no longer exists, so creating a new one", id, user)
 		}
-		gc.DeleteMap(delete)
-		log.Fatalf("updating map %s: %v", mapId, err)
 	}
-	log.Printf("updated map %s with locations\n", mapId)
-
-	publish := geoguessr.PublishMapRequest{
-		Id: mapId,
+	if mapId == "" {
+		mapId, token, err = publisher.Publish(ctx, publication)
+		if err == nil && persistentMap {
+			runs.SetPersistentMap(user, mapId)
+		}
 	}
-	err = gc.PublishMap(publish)
 	if err != nil {
-		delete := geoguessr.DeleteMapRequest{
-			Id: mapId,
-		}
-		gc.DeleteMap(delete)
-		log.Fatalf("publishing map %s: %v", mapId, err)
+		log.Fatalf("publishing drill: %v", err)
 	}
+	log.Printf(`published map "%s" with id %s`, mapName, mapId)
+	log.Println(geoguessr.ChallengeLink(mapId, token))
 
-	challenge := geoguessr.CreateChallengeRequest{
-		AccessLevel: 1,
-		NoMoving:    true,
-		NoPanning:   false,
-		NoZooming:   false,
-		Map:         mapId,
-		TimeLimit:   0,
-	}
-	link, err := gc.CreateChallenge(challenge)
+	run, err := store.NewRun(user, mapId, time.Now())
 	if err != nil {
-		delete := geoguessr.DeleteMapRequest{
-			Id: mapId,
+		log.Fatalf("creating run: %v", err)
+	}
+	run.ChallengeToken = token
+	for i, location := range locations {
+		source := sources[i]
+		source.Latitude = location.Location[0]
+		source.Longitude = location.Location[1]
+		source.PanoId = location.Id
+		source.PanoDate = location.Date
+		source.Copyright = location.Copyright
+		source.CoverageType = string(location.Type)
+		if location.Source != "google" {
+			source.Source = location.Source
 		}
-		gc.DeleteMap(delete)
-		log.Fatalf("creating challenge for map %s: %v", mapId, err)
+		run.Locations = append(run.Locations, source)
 	}
-	log.Println(link)
+	runs.Add(run)
 
-	calls := 0
-	for _, n := range sv.APICalls {
-		calls += n
+	err = runs.Save()
+	if err != nil {
+		log.Fatalf("saving run: %v", err)
+	}
+	log.Printf("grade this drill with: georep grade -user %s -challenge %s", user, token)
+
+	fmt.Printf("used %d Google Maps API calls\n", sv.APICalls.Total())
+	fmt.Print(sv.Budget.Report())
+
+	if sv.Cache != nil {
+		hits := 0
+		for _, n := range sv.Cache.Hits {
+			hits += n
+		}
+		fmt.Printf("saved %d Google Maps API lookups with the cache\n", hits)
 	}
-	fmt.Printf("used %d Google Maps API calls\n", calls)
-	s, _ := json.MarshalIndent(sv.APICalls, "", "\t")
-	fmt.Print(string(s))
 }
 
-func deleteOldMaps(gc *geoguessr.GeoguessrClient /* d time.Duration */) error {
-	maps, err := gc.ListMaps()
+// Reports whether the map is still on the account. Errors count as the map existing, so that it isn't
+// replaced because of a hiccup.
+func mapExists(ctx context.Context, gc *geoguessr.GeoguessrClient, mapId string) bool {
+	maps, err := gc.ListMaps(ctx)
 	if err != nil {
-		return fmt.Errorf("listing maps: %v", err)
+		log.Printf("listing maps: %v", err)
+		return true
 	}
 
-	deleted := 0
 	for _, m := range maps {
-		// if time.Now().Add(-d).After(m.CreatedAt) {
-		delete := geoguessr.DeleteMapRequest{
-			Id: m.ID,
-		}
-		err = gc.DeleteMap(delete)
-		if err != nil {
-			return fmt.Errorf("deleting map %s: %v", m.ID, err)
+		if m.ID == mapId {
+			return true
 		}
-		deleted++
-		// }
 	}
+	return false
+}
 
-	log.Printf("deleted %d maps\n", deleted)
-	return nil
+// Prints what the run spent if it was stopped by the budget, since it won't get to print it at the
+// end.
+func reportOverBudget(budget *googlemaps.Budget, err error) {
+	var budgetErr *googlemaps.BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		fmt.Print(budget.Report())
+	}
 }
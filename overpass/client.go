@@ -1,36 +1,49 @@
 package overpass
 
 import (
+	"context"
+	_ "embed"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"georep/internal/transport"
+	"net/url"
 	"strings"
+	"time"
 )
 
-func NewOverpassClient() (*OverpassClient, error) {
-	file, err := os.ReadFile("D:/geosr/overpass/bounding_boxes.json")
-	if err != nil {
-		return nil, fmt.Errorf("reading bounding boxes file: %v", err)
-	}
+// Queries can take a while on the public Overpass instance, especially for large bounding boxes.
+const queryTimeout = 3 * time.Minute
+
+// Escapes a value for use inside a double-quoted string in Overpass QL.
+var escapeString = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+
+// Bounding boxes of each country as south,west,north,east.
+//
+//go:embed bounding_boxes.json
+var boundingBoxesFile []byte
 
+func NewOverpassClient() (*OverpassClient, error) {
 	var boundingBoxes map[string]string
-	err = json.Unmarshal(file, &boundingBoxes)
+	err := json.Unmarshal(boundingBoxesFile, &boundingBoxes)
 	if err != nil {
 		return nil, fmt.Errorf("unmarshaling bounding boxes file: %v", err)
 	}
 
+	client := transport.New(nil)
+	client.Timeout = queryTimeout
+
 	return &OverpassClient{
-		Client:        http.DefaultClient,
+		Client:        client,
 		BoundingBoxes: boundingBoxes,
 	}, nil
 }
 
-func (oc *OverpassClient) GetLocationsOnRoad(country string, road string) ([]Latlong, error) {
+// Returns the geometry of every way tagged with the road's ref in the country, with connected ways
+// stitched together into polylines.
+func (oc *OverpassClient) GetRoad(ctx context.Context, country string, road string) ([][]Latlong, error) {
 	bbox, ok := oc.BoundingBoxes[country]
 	if !ok {
-		return []Latlong{}, fmt.Errorf("country %s not found in bounding boxes file", country)
+		return [][]Latlong{}, fmt.Errorf("country %s not found in bounding boxes file", country)
 	}
 
 	query := fmt.Sprintf(`
@@ -38,34 +51,135 @@ func (oc *OverpassClient) GetLocationsOnRoad(country string, road string) ([]Lat
 	way[highway]["ref"="%s"](%s);
 	(._;>;);
 	out body;
-	`, road, bbox)
+	`, escapeString(road), bbox)
 
-	resp, err := oc.Client.Post("https://overpass-api.de/api/interpreter", "application/x-www-form-urlencoded", strings.NewReader("data="+query))
-	if err != nil {
-		return []Latlong{}, fmt.Errorf("failed to query Overpass API: %v", err)
+	return oc.getWays(ctx, query)
+}
+
+// Returns the geometry of every major road (tertiary and up) in the box between the south-west and
+// north-east corners, with connected ways stitched together into polylines.
+func (oc *OverpassClient) GetRoadsInBox(ctx context.Context, min Latlong, max Latlong) ([][]Latlong, error) {
+	query := fmt.Sprintf(`
+	[out:json][timeout:90];
+	way[highway~"^(motorway|trunk|primary|secondary|tertiary)$"](%f,%f,%f,%f);
+	(._;>;);
+	out body;
+	`, min.Latitude, min.Longitude, max.Latitude, max.Longitude)
+
+	return oc.getWays(ctx, query)
+}
+
+func (oc *OverpassClient) getWays(ctx context.Context, query string) ([][]Latlong, error) {
+	// Queries only read, so they can be retried even though they are POSTed.
+	req := transport.Request{
+		Method:      "POST",
+		URL:         "https://overpass-api.de/api/interpreter",
+		Body:        []byte("data=" + url.QueryEscape(query)),
+		ContentType: "application/x-www-form-urlencoded",
+		API:         "Overpass API",
+		Idempotent:  true,
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := oc.Client.Do(ctx, req)
 	if err != nil {
-		return []Latlong{}, fmt.Errorf("failed to read response body: %v", err)
+		return [][]Latlong{}, fmt.Errorf("failed to query Overpass API: %w", err)
 	}
 
 	var overpassResp OverpassResponse
 	err = json.Unmarshal(body, &overpassResp)
 	if err != nil {
-		return []Latlong{}, fmt.Errorf("failed to parse Overpass API response: %v", err)
+		return [][]Latlong{}, fmt.Errorf("failed to parse Overpass API response: %v", err)
 	}
 
-	coordinates := make([]Latlong, 0)
+	nodes := make(map[int64]Latlong)
+	ways := make([][]int64, 0)
 	for _, el := range overpassResp.Elements {
-		if el.Type == "node" {
-			coordinates = append(coordinates, Latlong{el.Lat, el.Lon})
+		switch el.Type {
+		case "node":
+			nodes[el.ID] = Latlong{el.Lat, el.Lon}
+		case "way":
+			if len(el.Nodes) > 1 {
+				ways = append(ways, el.Nodes)
+			}
+		}
+	}
+
+	if len(ways) == 0 {
+		return [][]Latlong{}, fmt.Errorf("no ways found in Overpass API response")
+	}
+
+	polylines := make([][]Latlong, 0)
+	for _, way := range stitchWays(ways) {
+		polyline := make([]Latlong, 0, len(way))
+		for _, id := range way {
+			if node, ok := nodes[id]; ok {
+				polyline = append(polyline, node)
+			}
+		}
+		if len(polyline) > 1 {
+			polylines = append(polylines, polyline)
+		}
+	}
+	return polylines, nil
+}
+
+// Joins ways that share an end node into longer ways. A road is usually split into many ways (at
+// bridges, junctions, changes in speed limit, ...) that are returned in no particular order.
+func stitchWays(ways [][]int64) [][]int64 {
+	ends := make(map[int64][]int)
+	for i, way := range ways {
+		ends[way[0]] = append(ends[way[0]], i)
+		ends[way[len(way)-1]] = append(ends[way[len(way)-1]], i)
+	}
+
+	used := make([]bool, len(ways))
+	next := func(node int64) ([]int64, bool) {
+		for _, i := range ends[node] {
+			if used[i] {
+				continue
+			}
+			used[i] = true
+
+			way := ways[i]
+			if way[0] != node {
+				way = reversed(way)
+			}
+			return way, true
 		}
+		return nil, false
 	}
 
-	if len(coordinates) == 0 {
-		return []Latlong{}, fmt.Errorf("no nodes found in Overpass API response")
+	stitched := make([][]int64, 0)
+	for i, way := range ways {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+
+		line := append([]int64{}, way...)
+		for {
+			more, ok := next(line[len(line)-1])
+			if !ok {
+				break
+			}
+			line = append(line, more[1:]...)
+		}
+		for {
+			more, ok := next(line[0])
+			if !ok {
+				break
+			}
+			line = append(reversed(more[1:]), line...)
+		}
+		stitched = append(stitched, line)
+	}
+	return stitched
+}
+
+func reversed(way []int64) []int64 {
+	r := make([]int64, len(way))
+	for i, node := range way {
+		r[len(way)-1-i] = node
 	}
-	return coordinates, nil
+	return r
 }
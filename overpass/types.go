@@ -1,9 +1,9 @@
 package overpass
 
-import "net/http"
+import "georep/internal/transport"
 
 type OverpassClient struct {
-	Client        *http.Client
+	Client        *transport.Client
 	BoundingBoxes map[string]string
 }
 
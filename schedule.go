@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"georep/data"
+	"georep/schedule"
+	"log"
+	"time"
+)
+
+// Adds every subdivision of a country (or just one of them) to the user's deck.
+func enroll(args []string) {
+	var (
+		country     string
+		subdivision string
+		user        string
+	)
+
+	flags := flag.NewFlagSet("enroll", flag.ExitOnError)
+	flags.StringVar(&country, "country", "", "country to drill the subdivisions of")
+	flags.StringVar(&subdivision, "subdivision", "", "single first-order subdivision to drill instead of the whole country")
+	flags.StringVar(&user, "user", "", "user id")
+
+	flags.Parse(args)
+	if country == "" || user == "" {
+		log.Fatalf("country and user must be specified")
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("finding state directory: %v", err)
+	}
+
+	deck, err := schedule.LoadDeck(dir, user)
+	if err != nil {
+		log.Fatalf("loading deck for %s: %v", user, err)
+	}
+
+	var features []data.Feature
+	if subdivision != "" {
+		feature, err := data.FindSubdivision(country, subdivision)
+		if err != nil {
+			log.Fatalf("finding subdivision: %v", err)
+		}
+		features = []data.Feature{feature}
+	} else {
+		features, err = data.GetSubdivisions(country)
+		if err != nil {
+			log.Fatalf("getting subdivisions of %s: %v", country, err)
+		}
+	}
+
+	now := time.Now()
+	added := 0
+	for _, feature := range features {
+		card := schedule.NewCard(feature.Properties.Adm1Code, country, feature.Properties.NameEn, now)
+		if deck.Add(card) {
+			added++
+		}
+	}
+
+	err = deck.Save(dir)
+	if err != nil {
+		log.Fatalf("saving deck for %s: %v", user, err)
+	}
+	log.Printf("enrolled %s in %d new subdivisions of %s", user, added, country)
+}
+
+// Records a self-reported grade for a subdivision.
+func review(args []string) {
+	var (
+		country     string
+		grade       int
+		subdivision string
+		user        string
+	)
+
+	flags := flag.NewFlagSet("review", flag.ExitOnError)
+	flags.StringVar(&country, "country", "", "country containing the subdivision")
+	flags.IntVar(&grade, "grade", -1, "how well the subdivision was recalled, from 0 (not at all) to 5 (perfectly)")
+	flags.StringVar(&subdivision, "subdivision", "", "first-order subdivision that was drilled")
+	flags.StringVar(&user, "user", "", "user id")
+
+	flags.Parse(args)
+	if country == "" || subdivision == "" || user == "" {
+		log.Fatalf("country, subdivision and user must be specified")
+	}
+	if grade < int(schedule.GradeBlackout) || grade > int(schedule.GradePerfect) {
+		log.Fatalf("grade must be between %d and %d", schedule.GradeBlackout, schedule.GradePerfect)
+	}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Fatalf("finding state directory: %v", err)
+	}
+
+	deck, err := schedule.LoadDeck(dir, user)
+	if err != nil {
+		log.Fatalf("loading deck for %s: %v", user, err)
+	}
+
+	feature, err := data.FindSubdivision(country, subdivision)
+	if err != nil {
+		log.Fatalf("finding subdivision: %v", err)
+	}
+	card, ok := deck.Cards[feature.Properties.Adm1Code]
+	if !ok {
+		log.Fatalf("%s is not enrolled in %s, %s", user, subdivision, country)
+	}
+	card.Review(schedule.Grade(grade), time.Now())
+
+	err = deck.Save(dir)
+	if err != nil {
+		log.Fatalf("saving deck for %s: %v", user, err)
+	}
+	log.Printf("%s, %s is next due on %s", card.Subdivision, card.Country, card.Due.Format(time.DateOnly))
+}
@@ -0,0 +1,30 @@
+package schedule
+
+import "math"
+
+// Grades a guess by how far it landed from the location relative to the size of the subdivision, so
+// that missing Texas by 100km is graded more kindly than missing Rhode Island by the same distance.
+func GradeGuess(distanceInMeters float64, areaSqkm float64) Grade {
+	// Radius of a circle with the same area as the subdivision.
+	radius := math.Sqrt(areaSqkm/math.Pi) * 1000
+
+	switch {
+	case distanceInMeters <= radius/2:
+		return GradePerfect
+	case distanceInMeters <= radius:
+		return GradeCorrectHesitant
+	case distanceInMeters <= 2*radius:
+		return GradeCorrectDifficult
+	case distanceInMeters <= 4*radius:
+		return GradeIncorrectFamiliar
+	case distanceInMeters <= 8*radius:
+		return GradeIncorrect
+	default:
+		return GradeBlackout
+	}
+}
+
+// Grades a guess by its GeoGuessr score alone, for when the size of the subdivision is unknown.
+func GradeScore(points int) Grade {
+	return min(max(Grade(points/1000), GradeBlackout), GradePerfect)
+}
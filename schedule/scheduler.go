@@ -0,0 +1,143 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	initialEase = 2.5
+	minimumEase = 1.3
+)
+
+func NewCard(adm1Code string, country string, subdivision string, now time.Time) *Card {
+	return &Card{
+		Adm1Code:    adm1Code,
+		Country:     country,
+		Subdivision: subdivision,
+		Ease:        initialEase,
+		Due:         now,
+	}
+}
+
+// Updates the card using the SM-2 algorithm.
+func (c *Card) Review(grade Grade, now time.Time) {
+	grade = min(max(grade, GradeBlackout), GradePerfect)
+
+	if grade >= GradeCorrectDifficult {
+		switch c.Repetitions {
+		case 0:
+			c.Interval = 1
+		case 1:
+			c.Interval = 6
+		default:
+			c.Interval = int(math.Round(float64(c.Interval) * c.Ease))
+		}
+		c.Repetitions++
+	} else {
+		// Forgotten cards start over, but keep their (reduced) ease.
+		c.Repetitions = 0
+		c.Interval = 1
+	}
+
+	q := float64(GradePerfect - grade)
+	c.Ease = max(c.Ease+0.1-q*(0.08+q*0.02), minimumEase)
+	c.LastReview = now
+	c.Due = now.AddDate(0, 0, c.Interval)
+}
+
+// Adds a card to the deck unless it is already there. Returns whether the card was added.
+func (d *Deck) Add(card *Card) bool {
+	if _, ok := d.Cards[card.Adm1Code]; ok {
+		return false
+	}
+	d.Cards[card.Adm1Code] = card
+	return true
+}
+
+// Returns up to n cards that are due, most overdue first. A country of "" matches every card.
+func (d *Deck) Due(now time.Time, country string, n int) []*Card {
+	due := make([]*Card, 0)
+	for _, card := range d.Cards {
+		if country != "" && card.Country != country {
+			continue
+		}
+		if !card.Due.After(now) {
+			due = append(due, card)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].Due.Equal(due[j].Due) {
+			return due[i].Due.Before(due[j].Due)
+		}
+		return due[i].Adm1Code < due[j].Adm1Code
+	})
+
+	if len(due) > n {
+		due = due[:n]
+	}
+	return due
+}
+
+func deckPath(dir string, user string) string {
+	return filepath.Join(dir, "decks", user+".json")
+}
+
+// Loads the user's deck from dir. A user without a deck gets an empty one.
+func LoadDeck(dir string, user string) (*Deck, error) {
+	deck := &Deck{
+		User:  user,
+		Cards: make(map[string]*Card),
+	}
+
+	file, err := os.ReadFile(deckPath(dir, user))
+	if errors.Is(err, os.ErrNotExist) {
+		return deck, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading deck: %v", err)
+	}
+
+	err = json.Unmarshal(file, deck)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling deck: %v", err)
+	}
+	if deck.Cards == nil {
+		deck.Cards = make(map[string]*Card)
+	}
+
+	return deck, nil
+}
+
+func (d *Deck) Save(dir string) error {
+	path := deckPath(dir, d.User)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating deck directory: %v", err)
+	}
+
+	payload, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshaling deck: %v", err)
+	}
+
+	// Write to a temporary file first so that a crash never leaves a truncated deck behind.
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, payload, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing deck: %v", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("replacing deck: %v", err)
+	}
+
+	return nil
+}
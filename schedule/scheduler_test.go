@@ -0,0 +1,119 @@
+package schedule
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestReview(t *testing.T) {
+	tests := []struct {
+		name      string
+		grades    []Grade
+		intervals []int
+		ease      float64
+	}{
+		{
+			name:      "perfect",
+			grades:    []Grade{GradePerfect, GradePerfect, GradePerfect, GradePerfect},
+			intervals: []int{1, 6, 16, 45},
+			ease:      2.9,
+		},
+		{
+			name:      "hesitant keeps the ease",
+			grades:    []Grade{GradeCorrectHesitant, GradeCorrectHesitant, GradeCorrectHesitant, GradeCorrectHesitant},
+			intervals: []int{1, 6, 15, 38},
+			ease:      2.5,
+		},
+		{
+			name:      "difficult lowers the ease",
+			grades:    []Grade{GradeCorrectDifficult, GradeCorrectDifficult, GradeCorrectDifficult},
+			intervals: []int{1, 6, 13},
+			ease:      2.08,
+		},
+		{
+			name:      "lapse starts over",
+			grades:    []Grade{GradePerfect, GradePerfect, GradePerfect, GradeIncorrect, GradePerfect, GradePerfect},
+			intervals: []int{1, 6, 16, 1, 1, 6},
+			ease:      2.46,
+		},
+		{
+			name:      "ease floor",
+			grades:    []Grade{GradeBlackout, GradeBlackout, GradeBlackout},
+			intervals: []int{1, 1, 1},
+			ease:      minimumEase,
+		},
+		{
+			name:      "out of range grades are clamped",
+			grades:    []Grade{Grade(9), Grade(-3)},
+			intervals: []int{1, 1},
+			ease:      1.8,
+		},
+	}
+
+	for _, test := range tests {
+		card := NewCard("XXX-1", "Country", "Subdivision", start)
+		now := start
+		intervals := make([]int, 0, len(test.grades))
+		for _, grade := range test.grades {
+			card.Review(grade, now)
+			intervals = append(intervals, card.Interval)
+			if want := now.AddDate(0, 0, card.Interval); !card.Due.Equal(want) || !card.LastReview.Equal(now) {
+				t.Errorf("%s: due %v and last reviewed %v after a review at %v", test.name, card.Due, card.LastReview, now)
+			}
+			now = card.Due
+		}
+
+		if !reflect.DeepEqual(intervals, test.intervals) {
+			t.Errorf("%s: intervals %v, want %v", test.name, intervals, test.intervals)
+		}
+		if math.Abs(card.Ease-test.ease) > 1e-9 {
+			t.Errorf("%s: ease %v, want %v", test.name, card.Ease, test.ease)
+		}
+	}
+}
+
+func TestDue(t *testing.T) {
+	deck := &Deck{Cards: make(map[string]*Card)}
+	for _, card := range []*Card{
+		NewCard("B-2", "B", "b2", start.Add(-2*time.Hour)),
+		NewCard("A-2", "A", "a2", start.Add(-time.Hour)),
+		NewCard("A-1", "A", "a1", start.Add(-time.Hour)),
+		NewCard("A-3", "A", "a3", start.Add(-3*time.Hour)),
+		NewCard("A-4", "A", "a4", start),
+		NewCard("A-5", "A", "a5", start.Add(time.Hour)),
+	} {
+		if !deck.Add(card) {
+			t.Fatalf("expected %s to be added", card.Adm1Code)
+		}
+	}
+	if deck.Add(NewCard("A-1", "A", "a1", start)) {
+		t.Errorf("expected a card already in the deck not to be added again")
+	}
+
+	tests := []struct {
+		name    string
+		country string
+		n       int
+		want    []string
+	}{
+		{"most overdue first", "", 10, []string{"A-3", "B-2", "A-1", "A-2", "A-4"}},
+		{"limited", "", 2, []string{"A-3", "B-2"}},
+		{"one country", "A", 10, []string{"A-3", "A-1", "A-2", "A-4"}},
+		{"unknown country", "C", 10, []string{}},
+	}
+
+	for _, test := range tests {
+		due := deck.Due(start, test.country, test.n)
+		got := make([]string, 0, len(due))
+		for _, card := range due {
+			got = append(got, card.Adm1Code)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: due %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package schedule
+
+import "time"
+
+// Quality of a review on the SM-2 scale, from 0 (complete blackout) to 5 (perfect recall).
+type Grade int
+
+const (
+	GradeBlackout Grade = iota
+	GradeIncorrect
+	GradeIncorrectFamiliar
+	GradeCorrectDifficult
+	GradeCorrectHesitant
+	GradePerfect
+)
+
+// A card is a single subdivision that a user is drilling.
+type Card struct {
+	Adm1Code    string `json:"adm1Code"`
+	Country     string `json:"country"`
+	Subdivision string `json:"subdivision"`
+
+	Ease        float64   `json:"ease"`
+	Interval    int       `json:"interval"`
+	Repetitions int       `json:"repetitions"`
+	Due         time.Time `json:"due"`
+	LastReview  time.Time `json:"lastReview,omitempty"`
+}
+
+// Cards are keyed by their Natural Earth adm1_code.
+type Deck struct {
+	User  string           `json:"user"`
+	Cards map[string]*Card `json:"cards"`
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Opens the store in dir. A missing store is treated as empty and created on the first save.
+func Open(dir string) (*Store, error) {
+	store := &Store{
+		path:     filepath.Join(dir, "runs.json"),
+		Runs:     make([]*Run, 0),
+		Maps:     make(map[string]string),
+		Defaults: make(map[string]Defaults),
+	}
+
+	file, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading store: %v", err)
+	}
+
+	err = json.Unmarshal(file, store)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling store: %v", err)
+	}
+
+	return store, nil
+}
+
+// Creates a run with a fresh id. The run is not recorded until it is added.
+func NewRun(user string, mapId string, now time.Time) (*Run, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("generating run id: %v", err)
+	}
+
+	return &Run{
+		Id:        hex.EncodeToString(id),
+		User:      user,
+		MapId:     mapId,
+		Created:   now,
+		Locations: make([]Location, 0),
+	}, nil
+}
+
+func (s *Store) Add(run *Run) {
+	s.Runs = append(s.Runs, run)
+	s.change("run", run.Id)
+}
+
+func (s *Store) SetGraded(run *Run, now time.Time) {
+	run.Graded = now
+	s.change("run", run.Id)
+}
+
+func (s *Store) FindByToken(token string) (*Run, bool) {
+	for _, run := range s.Runs {
+		if run.ChallengeToken == token {
+			return run, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the id of the user's long-lived map, if they have one.
+func (s *Store) PersistentMap(user string) (string, bool) {
+	mapId, ok := s.Maps[user]
+	return mapId, ok
+}
+
+func (s *Store) SetPersistentMap(user string, mapId string) {
+	if s.Maps == nil {
+		s.Maps = make(map[string]string)
+	}
+	s.Maps[user] = mapId
+	s.change("map", user)
+}
+
+func (s *Store) SetDefaults(user string, defaults Defaults) {
+	if s.Defaults == nil {
+		s.Defaults = make(map[string]Defaults)
+	}
+	s.Defaults[user] = defaults
+	s.change("defaults", user)
+}
+
+// Reports whether the map is any user's long-lived map.
+func (s *Store) IsPersistentMap(mapId string) bool {
+	for _, id := range s.Maps {
+		if id == mapId {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the runs that used the map.
+func (s *Store) FindByMap(mapId string) []*Run {
+	runs := make([]*Run, 0)
+	for _, run := range s.Runs {
+		if run.MapId == mapId {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// Returns the user's runs, newest first.
+func (s *Store) History(user string) []*Run {
+	runs := make([]*Run, 0)
+	for _, run := range s.Runs {
+		if run.User == user {
+			runs = append(runs, run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Created.After(runs[j].Created)
+	})
+	return runs
+}
+
+func (s *Store) change(kind string, id string) {
+	if s.changed == nil {
+		s.changed = make(map[string]bool)
+	}
+	s.changed[kind+":"+id] = true
+}
+
+// Re-reads the store and writes it back with the changes made through this copy, so that whatever
+// other commands saved since it was opened, like a run graded during a long drill, isn't lost.
+func (s *Store) Save() error {
+	disk, err := Open(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]int)
+	for i, run := range disk.Runs {
+		index[run.Id] = i
+	}
+	for _, run := range s.Runs {
+		if !s.changed["run:"+run.Id] {
+			continue
+		}
+		if i, ok := index[run.Id]; ok {
+			disk.Runs[i] = run
+		} else {
+			disk.Runs = append(disk.Runs, run)
+		}
+	}
+	for user, mapId := range s.Maps {
+		if s.changed["map:"+user] {
+			disk.Maps[user] = mapId
+		}
+	}
+	for user, defaults := range s.Defaults {
+		if s.changed["defaults:"+user] {
+			disk.Defaults[user] = defaults
+		}
+	}
+
+	err = disk.write()
+	if err != nil {
+		return err
+	}
+
+	s.Runs, s.Maps, s.Defaults = disk.Runs, disk.Maps, disk.Defaults
+	s.changed = nil
+	return nil
+}
+
+func (s *Store) write() error {
+	err := os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating store directory: %v", err)
+	}
+
+	payload, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshaling store: %v", err)
+	}
+
+	// Write to a temporary file first so that a crash never leaves a truncated store behind.
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, payload, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing store: %v", err)
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return fmt.Errorf("replacing store: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package store
+
+import "time"
+
+// The runs of every user, persisted as a single JSON file.
+type Store struct {
+	path string
+
+	// Runs, maps and defaults set through this copy of the store, keyed by kind and id, which are the
+	// only ones that replace what is on disk when it is saved.
+	changed map[string]bool
+
+	Runs []*Run `json:"runs"`
+
+	// Each user's long-lived map, for users who drill on the same map every session.
+	Maps map[string]string `json:"maps,omitempty"`
+
+	// Each user's defaults for the drill command.
+	Defaults map[string]Defaults `json:"defaults,omitempty"`
+}
+
+// Zero values leave the drill command's own defaults in place.
+type Defaults struct {
+	Rules  string `json:"rules,omitempty"`
+	Rounds int    `json:"rounds,omitempty"`
+}
+
+// A run is one generated map and the challenge published for it.
+type Run struct {
+	Id             string     `json:"id"`
+	User           string     `json:"user"`
+	MapId          string     `json:"mapId"`
+	ChallengeToken string     `json:"challengeToken"`
+	Created        time.Time  `json:"created"`
+	Graded         time.Time  `json:"graded"`
+	Locations      []Location `json:"locations"`
+}
+
+// Where a location came from. Adm1Code is set for locations drawn from a subdivision, not from a road.
+type Location struct {
+	Latitude    float64 `json:"lat"`
+	Longitude   float64 `json:"lng"`
+	Country     string  `json:"country"`
+	Subdivision string  `json:"subdivision,omitempty"`
+	Adm1Code    string  `json:"adm1Code,omitempty"`
+	Road        string  `json:"road,omitempty"`
+
+	// Metadata of the panorama at the location, when known. Source is the coverage provider it came
+	// from, which is Street View if empty.
+	PanoId       string `json:"panoId,omitempty"`
+	PanoDate     string `json:"panoDate,omitempty"`
+	Copyright    string `json:"copyright,omitempty"`
+	Source       string `json:"source,omitempty"`
+	CoverageType string `json:"coverageType,omitempty"`
+}